@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// listPermissionsHandler returns every permission code, along with the roles that grant it.
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	type permission struct {
+		Code  string   `json:"code"`
+		Roles []string `json:"roles"`
+	}
+
+	list := make([]permission, 0, len(permissions))
+	for _, code := range permissions {
+		p := permission{Code: code, Roles: []string{}}
+		for _, role := range roles {
+			if role.Permissions.Include(code) {
+				p.Roles = append(p.Roles, role.Name)
+			}
+		}
+		list = append(list, p)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listRolesHandler returns every role, along with the permissions it grants.
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listUserRolesHandler returns the roles granted to a user, along with their resulting permissions.
+// The permissions also include any that were granted to the user directly, which are listed separately as well.
+func (app *application) listUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+// addUserRoleHandler grants a role to a user. Granting a role that the user already has does nothing.
+func (app *application) addUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	err := app.models.Roles.AddForUser(r.Context(), user.ID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+// removeUserRoleHandler takes a role away from a user. Permissions granted to the user directly are not affected.
+func (app *application) removeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	role := httprouter.ParamsFromContext(r.Context()).ByName("role")
+
+	err := app.models.Roles.RemoveForUser(r.Context(), user.ID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+// addUserPermissionHandler grants a permission to a user directly, rather than through a role. Granting a permission that the user already has does nothing.
+func (app *application) addUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	// AddForUser skips unknown codes, so they have to be looked for first.
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !permissions.Include(code) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+// removeUserPermissionHandler takes a permission that was granted directly away from a user. The user keeps the permission if one of their roles grants it.
+func (app *application) removeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	err := app.models.Permissions.RemoveForUser(r.Context(), user.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserRoles(w, r, user.ID)
+}
+
+// addRolePermissionHandler adds a permission to a role, which grants it to every user with the role. Adding a permission that the role already has does nothing.
+func (app *application) addRolePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	name, code := params.ByName("role"), params.ByName("code")
+
+	err := app.models.Roles.AddPermission(r.Context(), name, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeRole(w, r, name)
+}
+
+// removeRolePermissionHandler takes a permission away from a role, and from every user with the role who isn't granted it some other way.
+func (app *application) removeRolePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	name, code := params.ByName("role"), params.ByName("code")
+
+	err := app.models.Roles.RemovePermission(r.Context(), name, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeRole(w, r, name)
+}
+
+// writeRole responds with the named role and its permissions.
+func (app *application) writeRole(w http.ResponseWriter, r *http.Request, name string) {
+	role, err := app.models.Roles.Get(r.Context(), name)
+	if err != nil {
+		switch {
+		// The role may have been deleted in the meantime.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserParam fetches the user identified by the "id" route parameter. If the user can't be found, an error response is sent and ok is false.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) (user *data.User, ok bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err = app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// writeUserRoles responds with the user's roles and effective permissions, as well as the permissions that were granted to the user directly.
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, userID int64) {
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetAllDirectForUser(r.Context(), userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Return empty lists rather than null.
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+	if direct == nil {
+		direct = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles, "permissions": permissions, "direct_permissions": direct}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
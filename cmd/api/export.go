@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushInterval is the number of movies written between each flush of the response.
+const exportFlushInterval = 100
+
+// exportWriteTimeout replaces the server's WriteTimeout for exports, which would otherwise cut off any export that takes longer than it.
+// Each batch of exportFlushInterval movies has this long to be written, so an export can run for as long as the client keeps reading it.
+const exportWriteTimeout = 30 * time.Second
+
+// movieWriter writes the movies in an export.
+type movieWriter interface {
+	// WriteHeader writes anything that has to come before the first movie.
+	WriteHeader() error
+	Write(movie *data.Movie) error
+}
+
+// ndjsonMovieWriter writes each movie as a JSON object on its own line.
+type ndjsonMovieWriter struct {
+	enc *json.Encoder
+}
+
+func (w ndjsonMovieWriter) WriteHeader() error {
+	return nil
+}
+
+func (w ndjsonMovieWriter) Write(movie *data.Movie) error {
+	return w.enc.Encode(movie)
+}
+
+// csvMovieWriter writes each movie as a CSV record, after a header row. Genres are separated with a '|' character, as in importMoviesHandler.
+type csvMovieWriter struct {
+	writer *csv.Writer
+}
+
+func (w csvMovieWriter) WriteHeader() error {
+	return w.write([]string{"id", "title", "year", "runtime", "genres", "version"})
+}
+
+func (w csvMovieWriter) Write(movie *data.Movie) error {
+	runtime, err := movie.Runtime.MarshalCSV()
+	if err != nil {
+		return err
+	}
+
+	return w.write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		runtime,
+		strings.Join(movie.Genres, "|"),
+		strconv.FormatInt(int64(movie.Version), 10),
+	})
+}
+
+// write flushes after every record, since the csv.Writer buffers its output (and any errors).
+func (w csvMovieWriter) write(record []string) error {
+	err := w.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// exportMoviesHandler streams every movie matching the same search criteria as listMoviesHandler, in either the ndjson (default) or csv format.
+// Movies are written as they are read from the database, so there is no limit on the size of an export.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input := app.readMovieSearch(qs, v)
+	format := app.readString(qs, "format", "ndjson")
+
+	v.Check(validator.In(format, "ndjson", "csv"), "format", "must be either ndjson or csv")
+
+	if data.ValidateMovieSearch(v, input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Search by the canonical form of each genre, as in listMoviesHandler.
+	genres, err := app.models.Genres.GetLookup(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	input.CanonicalGenres(genres)
+
+	var (
+		contentType string
+		writer      movieWriter
+	)
+
+	switch format {
+	case "csv":
+		contentType = "text/csv"
+		writer = csvMovieWriter{csv.NewWriter(w)}
+	default:
+		contentType = "application/x-ndjson"
+		writer = ndjsonMovieWriter{json.NewEncoder(w)}
+	}
+
+	// The ResponseController sees through the middleware's wrappers, as long as they implement Unwrap.
+	rc := http.NewResponseController(w)
+	extendDeadline := func() error {
+		err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if errors.Is(err, http.ErrNotSupported) {
+			return nil
+		}
+		return err
+	}
+
+	// The deadline is extended before the query is run, since it may take a while to return the first movie.
+	err = extendDeadline()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The headers aren't written until the first movie is ready, so that an error response can still be sent if the query fails.
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+		return writer.WriteHeader()
+	}
+
+	flusher, _ := w.(http.Flusher)
+	count := 0
+
+	err = app.models.Movies.Export(r.Context(), input, func(movie *data.Movie) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		err := writer.Write(movie)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushInterval == 0 {
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return extendDeadline()
+		}
+		return nil
+	})
+
+	// If nothing matched, then the export is still started so that a CSV export contains its header row.
+	if err == nil && !started {
+		err = start()
+	}
+
+	if err != nil {
+		// Once the export has started, the status can't be changed, so the client just receives a truncated body.
+		if started {
+			app.logError(r, err)
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string   `json:"name"`
+		Slug    string   `json:"slug"` // Defaults to the slug form of the name.
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Name: input.Name,
+		Slug: input.Slug,
+	}
+
+	if genre.Slug == "" {
+		genre.Slug = data.Slugify(genre.Name)
+	}
+
+	// Aliases are stored in their slug form, since that's what genres are looked up by.
+	genre.Aliases = []string{}
+	for _, alias := range input.Aliases {
+		genre.Aliases = append(genre.Aliases, data.Slugify(alias))
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(r.Context(), genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "the slug or one of the aliases is already in use")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readGenre fetches the genre named by the request's id parameter. If it can't be found, then an error response is sent and nil is returned.
+func (app *application) readGenre(w http.ResponseWriter, r *http.Request) *data.Genre {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	genre, err := app.models.Genres.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return genre
+}
+
+// updateGenreHandler renames a genre. If the slug changes, then every movie with the genre is updated, and the old slug is kept as an alias.
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre := app.readGenre(w, r)
+	if genre == nil {
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+		Slug *string `json:"slug"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+
+	if input.Slug != nil {
+		genre.Slug = *input.Slug
+	}
+
+	// A genre may be renamed to one of its own aliases, so the aliases aren't validated here. Update takes care of swapping the two.
+	genre.Aliases = nil
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Update(r.Context(), genre, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "is already in use by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the genre again, since its aliases may have changed.
+	genre, err = app.models.Genres.Get(r.Context(), genre.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeGenreHandler folds one genre into another. Every movie with the merged genre is given the other genre instead, and the merged genre's slug and aliases are kept as aliases.
+func (app *application) mergeGenreHandler(w http.ResponseWriter, r *http.Request) {
+	source := app.readGenre(w, r)
+	if source == nil {
+		return
+	}
+
+	var input struct {
+		Into int64 `json:"into"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Into > 0, "into", "must be a positive integer")
+	v.Check(input.Into != source.ID, "into", "must not be the genre being merged")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moviesUpdated, err := app.models.Genres.Merge(r.Context(), source.ID, input.Into, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		// The source genre was found above, so it must be the target that's missing.
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("into", "must be an existing genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	target, err := app.models.Genres.Get(r.Context(), input.Into)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": target, "movies_updated": moviesUpdated}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
 	"github.com/ejacobg/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
@@ -24,6 +28,54 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readVersionParam reads the version of a movie from the route parameters, in the same way as readIDParam.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// dispatchParam calls the handler registered under the value of the named route parameter, or fallback if there isn't one.
+// httprouter doesn't allow a static path segment in the same position as a named parameter, so routes like /v1/movies/export have to be dispatched from /v1/movies/:id instead.
+func (app *application) dispatchParam(name string, fallback http.HandlerFunc, handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName(name)]; ok {
+			handler(w, r)
+			return
+		}
+		fallback(w, r)
+	}
+}
+
+// movieETag returns an entity tag for the current version of the movie. Since the version changes on every update, the ETag can be derived from it without hashing the body.
+// Reviews and posters change the rating and poster_url without changing the version, so they are included as well.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%d-%g-%d"`, movie.ID, movie.Version, movie.RatingCount, movie.AverageRating, movie.PosterVersion)
+}
+
+// etagMatches reports whether the ETag is listed in an If-Match or If-None-Match header.
+// If-Match uses the strong comparison, so weak ETags (prefixed with W/) never match. If-None-Match uses the weak comparison, which ignores the prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 type envelope map[string]any
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -152,6 +204,23 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool will attempt to parse the given query parameter as a bool, returning a default value and writing to the validator if it fails.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
 // background runs the given function in a new goroutine, logging any panics that occur.
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
@@ -168,3 +237,61 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// retryAfter formats a wait as the number of seconds expected by the Retry-After header, rounding up so that clients don't retry too early.
+func retryAfter(wait time.Duration) string {
+	return strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10)
+}
+
+// clientIP returns the IP address of the client that made the request.
+// Forwarding headers are easily forged, so they are only believed when the request comes from a trusted proxy.
+// X-Forwarded-For is read from right to left, skipping trusted proxies, since only the entries added by trusted proxies can be relied on.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !app.trustedProxy(host) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+
+			host = hop
+			if !app.trustedProxy(hop) {
+				break
+			}
+		}
+		return host
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		if _, err := netip.ParseAddr(realIP); err == nil {
+			return realIP
+		}
+	}
+
+	return host
+}
+
+// trustedProxy reports whether the IP address belongs to one of the trusted proxies.
+func (app *application) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range app.config.proxy.trusted {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
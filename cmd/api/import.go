@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// importBatchSize is the number of movies saved per transaction, unless the import is atomic.
+	importBatchSize = 100
+
+	// importMaxBytes limits the size of an import body. This is much larger than the limit in readJSON, since an import may hold thousands of movies.
+	importMaxBytes = 32 << 20
+)
+
+// importResult reports what happened to a single line of an import.
+type importResult struct {
+	Line   int               `json:"line"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// movieRow holds a single movie read from an import, or the errors that stopped it from being read.
+type movieRow struct {
+	line   int
+	movie  *data.Movie
+	errors map[string]string
+}
+
+// movieReader returns the next row of an import, or io.EOF once every row has been read.
+// Any other error means that the rest of the body can't be read.
+type movieReader func() (movieRow, error)
+
+// newNDJSONMovieReader reads one JSON movie per line, using the same fields as createMovieHandler. Blank lines are skipped.
+func newNDJSONMovieReader(body io.Reader) movieReader {
+	scanner := bufio.NewScanner(body)
+	// Each line may be as large as a regular JSON request body.
+	scanner.Buffer(make([]byte, 64*1024), 1_048_576)
+
+	line := 0
+	return func() (movieRow, error) {
+		for scanner.Scan() {
+			line++
+
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+
+			var input struct {
+				Title   string       `json:"title"`
+				Year    int32        `json:"year"`
+				Runtime data.Runtime `json:"runtime"`
+				Genres  []string     `json:"genres"`
+			}
+
+			dec := json.NewDecoder(bytes.NewReader(text))
+			dec.DisallowUnknownFields()
+
+			err := dec.Decode(&input)
+			if err != nil {
+				return movieRow{line: line, errors: map[string]string{"json": err.Error()}}, nil
+			}
+
+			// As in readJSON, anything after the movie means that the line is malformed.
+			err = dec.Decode(&struct{}{})
+			if err != io.EOF {
+				return movieRow{line: line, errors: map[string]string{"json": "line must contain only a single JSON value"}}, nil
+			}
+
+			movie := &data.Movie{
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+			}
+
+			return movieRow{line: line, movie: movie}, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return movieRow{}, err
+		}
+		return movieRow{}, io.EOF
+	}
+}
+
+// newCSVMovieReader reads movies from CSV records. The first record must be a header naming the title, year, runtime and genres columns (in any order).
+// Runtimes may be given in minutes or in the "<runtime> mins" format, and genres are separated with a '|' character.
+func newCSVMovieReader(body io.Reader) (movieReader, error) {
+	reader := csv.NewReader(body)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("body must start with a CSV header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.In(name, "title", "year", "runtime", "genres") {
+			return nil, fmt.Errorf("CSV header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header must contain a %q column", name)
+		}
+	}
+
+	return func() (movieRow, error) {
+		record, err := reader.Read()
+		if err != nil {
+			// Malformed records only affect their own row.
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				return movieRow{line: parseError.StartLine, errors: map[string]string{"csv": parseError.Err.Error()}}, nil
+			}
+			return movieRow{}, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := movieRow{line: line, movie: &data.Movie{Title: record[columns["title"]], Genres: []string{}}}
+
+		year, err := strconv.ParseInt(strings.TrimSpace(record[columns["year"]]), 10, 32)
+		if err != nil {
+			row.errors = map[string]string{"year": "must be an integer value"}
+			return row, nil
+		}
+		row.movie.Year = int32(year)
+
+		err = row.movie.Runtime.UnmarshalCSV(record[columns["runtime"]])
+		if err != nil {
+			row.errors = map[string]string{"runtime": err.Error()}
+			return row, nil
+		}
+
+		for _, genre := range strings.Split(record[columns["genres"]], "|") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				row.movie.Genres = append(row.movie.Genres, genre)
+			}
+		}
+
+		return row, nil
+	}, nil
+}
+
+// importMoviesHandler creates movies from an application/x-ndjson or text/csv body, and reports the outcome of each line.
+// Valid movies are saved in batches, so a single invalid line won't stop the rest of the import. If the atomic query parameter is true, then either every movie is saved or none of them are.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	atomic := app.readBool(r.URL.Query(), "atomic", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+
+	var next movieReader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson":
+		next = newNDJSONMovieReader(r.Body)
+	case "text/csv":
+		var err error
+		next, err = newCSVMovieReader(r.Body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "application/x-ndjson", "text/csv")
+		return
+	}
+
+	// The genres are loaded once up front, rather than for every line.
+	genres, err := app.models.Genres.GetLookup(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	results := []importResult{}
+	created, failed := 0, 0
+
+	// batch holds the valid movies that have yet to be saved, and batchResults holds the index of each one's result.
+	var (
+		batch        []*data.Movie
+		batchResults []int
+	)
+
+	save := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := app.models.Movies.InsertMany(r.Context(), batch, app.contextGetUser(r).ID)
+		if err != nil {
+			return err
+		}
+
+		for i, movie := range batch {
+			results[batchResults[i]].ID = movie.ID
+		}
+		created += len(batch)
+
+		batch, batchResults = batch[:0], batchResults[:0]
+		return nil
+	}
+
+	// stop ends a non-atomic import early. The earlier batches have already been saved, so the results are still reported,
+	// with the lines of the unsaved batch marked with the reason, and the lines after them left out.
+	stop := func(status int, message, reason string) {
+		for _, i := range batchResults {
+			results[i].Errors = map[string]string{"movie": reason}
+		}
+		failed += len(batch)
+
+		env := envelope{
+			"error":   message,
+			"created": created,
+			"failed":  failed,
+			"results": results,
+		}
+		err := app.writeJSON(w, status, env, nil)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	saveFailed := func(err error) {
+		app.logError(r, err)
+		stop(http.StatusInternalServerError, "the server encountered a problem and could not finish the import", "could not be saved")
+	}
+
+	// lastLine is the last line that was read, so that a read error can say where the import stopped.
+	lastLine := 0
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				err = fmt.Errorf("body must not be larger than %d bytes", importMaxBytes)
+			case errors.Is(err, bufio.ErrTooLong):
+				err = fmt.Errorf("body contains a line longer than %d bytes", 1_048_576)
+			}
+
+			// Nothing has been saved by an atomic import, but the earlier batches of a non-atomic import have, so the client needs to know which.
+			if atomic {
+				app.badRequestResponse(w, r, err)
+			} else {
+				stop(http.StatusBadRequest, fmt.Sprintf("%s; lines after line %d were not processed", err, lastLine), "not processed")
+			}
+			return
+		}
+
+		lastLine = row.line
+
+		result := importResult{Line: row.line, Errors: row.errors}
+
+		if row.movie != nil && result.Errors == nil {
+			rv := validator.New()
+			if data.ValidateMovie(rv, row.movie, genres); !rv.Valid() {
+				result.Errors = rv.Errors
+			}
+		}
+
+		results = append(results, result)
+
+		if result.Errors != nil {
+			failed++
+			continue
+		}
+
+		batch = append(batch, row.movie)
+		batchResults = append(batchResults, len(results)-1)
+
+		// Atomic imports are saved in a single transaction once every line has been checked.
+		if !atomic && len(batch) == importBatchSize {
+			if err := save(); err != nil {
+				saveFailed(err)
+				return
+			}
+		}
+	}
+
+	if atomic && failed > 0 {
+		env := envelope{"error": "the import contains invalid lines, so no movies were created", "results": results}
+		err := app.writeJSON(w, http.StatusUnprocessableEntity, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := save(); err != nil {
+		// An atomic import is saved in a single transaction, so nothing was created.
+		if atomic {
+			app.serverErrorResponse(w, r, err)
+		} else {
+			saveFailed(err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"created": created, "failed": failed, "results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
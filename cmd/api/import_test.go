@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// readRows reads every row from next, stopping at the first error.
+func readRows(t *testing.T, next movieReader) ([]movieRow, error) {
+	t.Helper()
+
+	var rows []movieRow
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestNDJSONMovieReader(t *testing.T) {
+	body := strings.Join([]string{
+		`{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`,
+		``,
+		`{"title": "Moana", "year": 2016} {"title": "Up"}`,
+		`{"title": "Moana", "rating": 5}`,
+		`not json`,
+	}, "\n")
+
+	rows, err := readRows(t, newNDJSONMovieReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The blank line is skipped, but still counted.
+	tests := []struct {
+		line      int
+		errorKey  string
+		wantMovie bool
+	}{
+		{1, "", true},
+		{3, "json", false},
+		{4, "json", false},
+		{5, "json", false},
+	}
+
+	if len(rows) != len(tests) {
+		t.Fatalf("got %d rows; want %d", len(rows), len(tests))
+	}
+
+	for i, tt := range tests {
+		row := rows[i]
+		if row.line != tt.line {
+			t.Errorf("row %d: got line %d; want %d", i, row.line, tt.line)
+		}
+		if (row.movie != nil) != tt.wantMovie {
+			t.Errorf("line %d: got movie %v; want a movie: %t", row.line, row.movie, tt.wantMovie)
+		}
+		if _, ok := row.errors[tt.errorKey]; tt.errorKey != "" && !ok {
+			t.Errorf("line %d: got errors %v; want a %q error", row.line, row.errors, tt.errorKey)
+		}
+	}
+
+	if movie := rows[0].movie; movie.Title != "Moana" || movie.Year != 2016 || movie.Runtime != 107 {
+		t.Errorf("got movie %+v", movie)
+	}
+}
+
+func TestNDJSONMovieReaderLongLine(t *testing.T) {
+	body := `{"title": "Moana"}` + "\n" + strings.Repeat("x", 2_000_000)
+
+	rows, err := readRows(t, newNDJSONMovieReader(strings.NewReader(body)))
+	if len(rows) != 1 {
+		t.Errorf("got %d rows before the error; want 1", len(rows))
+	}
+	if err == nil {
+		t.Error("got no error for a line that is too long")
+	}
+}
+
+func TestCSVMovieReader(t *testing.T) {
+	body := "Genres,Title,Year,Runtime\n" +
+		"animation|adventure,Moana,2016,107\n" +
+		"drama,Up,twenty,96 mins\n" +
+		"drama,Up,2009,ninety\n" +
+		"drama,\"Up,2009,96\n"
+
+	rows, err := readRows(t, mustCSVMovieReader(t, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows; want 4", len(rows))
+	}
+
+	if movie := rows[0].movie; rows[0].errors != nil || movie.Title != "Moana" || movie.Year != 2016 || movie.Runtime != 107 || len(movie.Genres) != 2 {
+		t.Errorf("line %d: got movie %+v and errors %v", rows[0].line, movie, rows[0].errors)
+	}
+	for i, key := range []string{"year", "runtime", "csv"} {
+		row := rows[i+1]
+		if _, ok := row.errors[key]; !ok {
+			t.Errorf("line %d: got errors %v; want a %q error", row.line, row.errors, key)
+		}
+	}
+}
+
+func TestCSVMovieReaderHeader(t *testing.T) {
+	for _, body := range []string{"", "title,year,runtime\n", "title,year,runtime,genres,rating\n"} {
+		if _, err := newCSVMovieReader(strings.NewReader(body)); err == nil {
+			t.Errorf("header %q: got no error", body)
+		}
+	}
+}
+
+func mustCSVMovieReader(t *testing.T, body string) movieReader {
+	t.Helper()
+
+	next, err := newCSVMovieReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return next
+}
+
+func TestImportMoviesReadError(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	token := newTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	// The first batch is saved before the reader reaches the long line, but the movie after it is not.
+	var lines []string
+	for i := 1; i <= importBatchSize+1; i++ {
+		lines = append(lines, fmt.Sprintf(`{"title": "Movie %d", "year": 2000, "runtime": "100 mins", "genres": ["drama"]}`, i))
+	}
+	lines = append(lines, strings.Repeat("x", 2_000_000))
+
+	code, _, body := ts.request(t, http.MethodPost, "/v1/movies/import", token, strings.Join(lines, "\n"), "Content-Type", "application/x-ndjson")
+	if code != http.StatusBadRequest {
+		t.Fatalf("got status %d; want %d", code, http.StatusBadRequest)
+	}
+
+	var res struct {
+		Error   string         `json:"error"`
+		Created int            `json:"created"`
+		Failed  int            `json:"failed"`
+		Results []importResult `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Created != importBatchSize || res.Failed != 1 || len(res.Results) != importBatchSize+1 {
+		t.Fatalf("got created %d, failed %d and %d results; want %d, 1 and %d", res.Created, res.Failed, len(res.Results), importBatchSize, importBatchSize+1)
+	}
+	if res.Results[0].ID == 0 {
+		t.Error("got no ID for a saved movie")
+	}
+	if last := res.Results[importBatchSize]; last.ID != 0 || last.Errors["movie"] != "not processed" {
+		t.Errorf("got %+v for the unsaved movie", last)
+	}
+	if !strings.Contains(res.Error, "line 101") {
+		t.Errorf("got error %q; want it to name the last line read", res.Error)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"github.com/pascaldekloe/jwt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newJWT creates a short-lived access token for the user.
+// The token carries its own ID (so that it can be revoked), and the family of the refresh token it was issued with (so that logging out can revoke both).
+func (app *application) newJWT(userID int64, family string) (string, error) {
+	jti, err := data.NewTokenFamily()
+	if err != nil {
+		return "", err
+	}
+
+	// Create a JWT claims struct to hold the information we will encode into our token.
+	var claims jwt.Claims
+
+	// Like our stateful token, we will store the user's ID.
+	// The Subject field is a string, so we have to convert the user ID.
+	claims.Subject = strconv.FormatInt(userID, 10)
+	claims.ID = jti
+
+	// The JWT is only valid for a short time. After that, the client should use its refresh token to get a new one.
+	claims.Issued = jwt.NewNumericTime(time.Now())
+	claims.NotBefore = jwt.NewNumericTime(time.Now())
+	claims.Expires = jwt.NewNumericTime(time.Now().Add(app.config.jwt.accessTTL))
+
+	// The Issuer and Audiences should be a unique value for our application.
+	claims.Issuer = app.config.jwt.issuer
+	claims.Audiences = []string{app.config.jwt.audience}
+
+	claims.Set = map[string]interface{}{"fam": family}
+
+	// Sign our claims using the current signing key. Its ID is recorded in the token's header, so that it can still be verified after the key is rotated.
+	jwtBytes, err := app.jwtKeys.Sign(&claims)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jwtBytes), nil
+}
+
+// newRefreshToken creates and saves a refresh token in the given family. Rotated tokens keep the expiry of the login that started the family.
+func (app *application) newRefreshToken(r *http.Request, userID int64, family string, expiry time.Time) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, time.Until(expiry), data.ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Family = family
+	token.UserAgent = r.UserAgent()
+	token.IP = app.clientIP(r)
+
+	err = app.models.Tokens.Insert(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// requestJWTClaims returns the claims of the JWT used to authenticate the request.
+// It should only be called after the authenticateJWT middleware has accepted the token.
+func (app *application) requestJWTClaims(r *http.Request) (*jwt.Claims, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return app.jwtKeys.Check([]byte(token), time.Now())
+}
+
+// jwksHandler publishes the public keys that verify our JWTs as a JSON Web Key Set, so that other services can verify them too.
+// Retired keys are removed from the set once their grace window ends.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.jwtKeys.JWKS(time.Now())}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshJWTHandler exchanges a refresh token for a new JWT and a new refresh token. Each refresh token can only be used once.
+// Using a refresh token a second time revokes every refresh token from the same login, since it means that the token has been copied.
+func (app *application) refreshJWTHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.RefreshToken != "", "refresh_token", "must be provided")
+	v.Check(len(input.RefreshToken) == 26, "refresh_token", "must be 26 bytes long")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	used, err := app.models.Tokens.UseRefresh(r.Context(), input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reused", map[string]string{"ip": app.clientIP(r)})
+			fallthrough
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("refresh_token", "invalid or expired refresh token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	refreshToken, err := app.newRefreshToken(r, used.UserID, used.Family, used.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accessToken, err := app.newJWT(used.UserID, used.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": accessToken, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteJWTHandler logs the user out by revoking the JWT used to make the request, along with its family of refresh tokens.
+func (app *application) deleteJWTHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := app.requestJWTClaims(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Revocations.Insert(r.Context(), claims.ID, claims.Expires.Time())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if family, ok := claims.String("fam"); ok {
+		err = app.models.Tokens.DeleteFamily(r.Context(), family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllJWTsHandler logs the user out of every session by deleting all of their refresh tokens, and rejecting every JWT issued to them before now.
+func (app *application) deleteAllJWTsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// loginBaseDelay is how long the client has to wait after its first failed login past the free attempts. It doubles with each further failure.
+const loginBaseDelay = time.Second
+
+// emailLoginPolicy applies to the failed logins for each email address. Accounts are locked once too many of their passwords have been guessed.
+func (app *application) emailLoginPolicy() data.LoginPolicy {
+	return data.LoginPolicy{
+		FreeAttempts:    app.config.login.freeAttempts,
+		BaseDelay:       loginBaseDelay,
+		MaxDelay:        app.config.login.maxDelay,
+		LockoutAfter:    app.config.login.lockoutAfter,
+		LockoutDuration: app.config.login.lockoutDuration,
+		Window:          app.config.login.window,
+	}
+}
+
+// ipLoginPolicy applies to the failed logins from each IP address. Since an IP may be shared by many users, it is allowed more failures and is never locked.
+func (app *application) ipLoginPolicy() data.LoginPolicy {
+	return data.LoginPolicy{
+		FreeAttempts: app.config.login.ipFreeAttempts,
+		BaseDelay:    loginBaseDelay,
+		MaxDelay:     app.config.login.maxDelay,
+		Window:       app.config.login.window,
+	}
+}
+
+// checkCredentials reads an email and password from the request body, and returns the user that they belong to.
+// Failed logins are counted against both the email address and the client's IP, and clients that fail too often have to wait before trying again.
+// If the credentials can't be checked, an error response is sent and nil is returned.
+func (app *application) checkCredentials(w http.ResponseWriter, r *http.Request) *data.User {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil
+	}
+
+	// Validate the email and password provided by the client.
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	ip := app.clientIP(r)
+
+	// Reserve the attempt before checking the password, so that a batch of concurrent guesses can't all go ahead before any of them is counted.
+	// This refuses the login outright if the email address or IP is still waiting out its previous failures, even if the password is correct.
+	attempt, ok, err := app.attemptLogin(r.Context(), input.Email, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+	if !ok {
+		app.loginThrottledResponse(w, r, attempt.wait())
+		return nil
+	}
+
+	// Check if the provided email exists, returning an error if we can't find it.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.loginFailed(w, r, attempt, nil)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	// Confirm that the provided password matches what's in the database.
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	// If the passwords don't match, return an error.
+	if !match {
+		app.loginFailed(w, r, attempt, user)
+		return nil
+	}
+
+	// A successful login clears the failures for the email address.
+	// The IP only has this attempt released, so that an attacker can't keep guessing other users' passwords by logging in to their own account.
+	err = app.loginSucceeded(r.Context(), attempt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil
+	}
+
+	return user
+}
+
+// loginAttempt is a login attempt that has been reserved against an email address and an IP. Both are nil if login throttling is disabled.
+type loginAttempt struct {
+	email, ip *data.LoginFailure
+}
+
+// wait returns how long the client has to wait before it may try to log in again.
+func (a loginAttempt) wait() time.Duration {
+	var wait time.Duration
+	for _, failure := range []*data.LoginFailure{a.email, a.ip} {
+		if failure == nil {
+			continue
+		}
+		if until := time.Until(failure.LockedUntil); until > wait {
+			wait = until
+		}
+	}
+	return wait
+}
+
+// attemptLogin reserves a login attempt against the email address and IP.
+// If either of them is still waiting out its previous failures, the attempt is refused and ok is false.
+func (app *application) attemptLogin(ctx context.Context, email, ip string) (attempt loginAttempt, ok bool, err error) {
+	if !app.config.login.enabled {
+		return attempt, true, nil
+	}
+
+	attempt.ip, ok, err = app.models.Logins.Attempt(ctx, data.LoginIPKey(ip), app.ipLoginPolicy())
+	if err != nil || !ok {
+		return attempt, ok, err
+	}
+
+	attempt.email, ok, err = app.models.Logins.Attempt(ctx, data.LoginEmailKey(email), app.emailLoginPolicy())
+	if err != nil || !ok {
+		// The password won't be checked, so the IP's attempt doesn't count as a failure.
+		if releaseErr := app.models.Logins.Release(ctx, attempt.ip.Key, app.ipLoginPolicy()); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+		return attempt, ok, err
+	}
+
+	return attempt, true, nil
+}
+
+// loginSucceeded clears the email address's failures, and releases the IP's attempt.
+func (app *application) loginSucceeded(ctx context.Context, attempt loginAttempt) error {
+	if !app.config.login.enabled {
+		return nil
+	}
+
+	err := app.models.Logins.Delete(ctx, attempt.email.Key)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Logins.Release(ctx, attempt.ip.Key, app.ipLoginPolicy())
+}
+
+// loginFailed sends an invalid credentials response for an attempt that failed. The attempt has already been counted.
+// If the client now has to wait before trying again, the Retry-After header says for how long.
+// The user is nil if no account has the email address. Otherwise, they are sent an email when their account is locked.
+func (app *application) loginFailed(w http.ResponseWriter, r *http.Request, attempt loginAttempt, user *data.User) {
+	if !app.config.login.enabled {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// Only the failure that locks the account is reported, rather than every failure while it stays locked.
+	if user != nil && attempt.email.Failures == app.config.login.lockoutAfter {
+		app.logger.PrintInfo("account locked", map[string]string{
+			"user_id": strconv.FormatInt(user.ID, 10),
+			"ip":      app.clientIP(r),
+		})
+
+		lockedUntil := attempt.email.LockedUntil
+		app.background(func() {
+			data := map[string]interface{}{
+				"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			}
+
+			err := app.mailer.Send(user.Email, "login_lockout.go.html", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	if wait := attempt.wait(); wait > 0 {
+		w.Header().Set("Retry-After", retryAfter(wait))
+	}
+	app.invalidCredentialsResponse(w, r)
+}
@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"github.com/ejacobg/greenlight/internal/data"
 	"github.com/ejacobg/greenlight/internal/jsonlog"
+	"github.com/ejacobg/greenlight/internal/jwtkeys"
 	"github.com/ejacobg/greenlight/internal/mailer"
+	"github.com/ejacobg/greenlight/internal/storage"
+	"net/netip"
 	"os"
 	"runtime"
 	"strings"
@@ -32,6 +36,7 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration // Per-query deadline, applied on top of the request's context.
 	}
 	// Settings for the rate limiter.
 	limiter struct {
@@ -39,6 +44,16 @@ type config struct {
 		burst   int     // Maximum number of simultaneous requests (bucket capacity)
 		enabled bool    // Allow enabling/disabling of rate limiter
 	}
+	// Settings for failed logins. Unlike the rate limiter, failures are counted in the database, so they are shared by every instance.
+	login struct {
+		enabled         bool          // Allow enabling/disabling of login throttling
+		freeAttempts    int           // Failed logins allowed for an email address before further attempts are delayed
+		ipFreeAttempts  int           // Failed logins allowed from an IP address before further attempts are delayed
+		maxDelay        time.Duration // Longest delay between attempts, short of a lockout
+		lockoutAfter    int           // Failed logins after which an account is locked (0 disables lockouts)
+		lockoutDuration time.Duration
+		window          time.Duration // How long failed logins are remembered for, after the last one
+	}
 	// Mailtrap inbox credentials.
 	smtp struct {
 		host     string
@@ -51,18 +66,42 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// Reverse proxy settings.
+	proxy struct {
+		trusted []netip.Prefix // Proxies whose X-Forwarded-For and X-Real-IP headers are believed.
+	}
 	// JWT settings.
 	jwt struct {
-		secret string
+		keyDir     string        // Directory of PEM-encoded signing keys. See the jwtkeys package.
+		keyGrace   time.Duration // How long retired keys keep verifying tokens after a new key is added.
+		issuer     string
+		audience   string
+		accessTTL  time.Duration // How long each JWT is valid for.
+		refreshTTL time.Duration // How long a login lasts before the user has to give their password again.
+	}
+	// Movie settings.
+	movies struct {
+		retention time.Duration // How long deleted movies are kept before they are purged. Zero keeps them forever.
+	}
+	// File storage settings.
+	storage struct {
+		dir string // Root directory of the filesystem storage backend.
+	}
+	// Cache settings.
+	cache struct {
+		ttl time.Duration // How long users and permissions are cached for. Zero disables the cache.
 	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage // Holds uploaded files, such as movie posters.
+	jwtKeys *jwtkeys.Set    // Signs and verifies JWTs. Nil when using stateful tokens.
+	wg      sync.WaitGroup
+	done    chan struct{} // Closed when the server begins shutting down, to stop long-running background tasks.
 }
 
 func main() {
@@ -78,12 +117,22 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "PostgreSQL per-query timeout")
 
 	// Rate limiter configuration
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter") // Rate limiter is on by default.
 
+	// Failed login configuration
+	flag.BoolVar(&cfg.login.enabled, "login-throttle-enabled", true, "Enable failed login throttling and lockouts")
+	flag.IntVar(&cfg.login.freeAttempts, "login-free-attempts", 3, "Failed logins per email address before attempts are delayed")
+	flag.IntVar(&cfg.login.ipFreeAttempts, "login-ip-free-attempts", 20, "Failed logins per IP address before attempts are delayed")
+	flag.DurationVar(&cfg.login.maxDelay, "login-max-delay", 15*time.Minute, "Longest delay between login attempts")
+	flag.IntVar(&cfg.login.lockoutAfter, "login-lockout-after", 10, "Failed logins after which an account is locked (0 to disable)")
+	flag.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", 15*time.Minute, "How long accounts are locked for")
+	flag.DurationVar(&cfg.login.window, "login-window", time.Hour, "How long failed logins are remembered for")
+
 	// Mailtrap configuration
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 0, "SMTP port")
@@ -97,9 +146,39 @@ func main() {
 		return nil
 	})
 
+	// Reverse proxy configuration
+	flag.Func("trusted-proxies", "Trusted reverse proxy addresses or CIDR ranges (space separated)", func(val string) error {
+		for _, field := range strings.Fields(val) {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(field)
+				if addrErr != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			cfg.proxy.trusted = append(cfg.proxy.trusted, prefix)
+		}
+		return nil
+	})
+
 	// JWT configuration
-	// If a secret is provided, then the application will use JWTs for authentication. Otherwise, it will default to using stateful tokens.
-	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT secret")
+	// If a key directory is provided, then the application will use JWTs for authentication. Otherwise, it will default to using stateful tokens.
+	flag.StringVar(&cfg.jwt.keyDir, "jwt-key-dir", "", "Directory of RSA or Ed25519 JWT signing keys (PEM)")
+	flag.DurationVar(&cfg.jwt.keyGrace, "jwt-key-grace", 24*time.Hour, "How long retired JWT keys keep verifying tokens (at least -jwt-access-ttl)")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "greenlight.ejacobg.com", "JWT issuer")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", "greenlight.ejacobg.com", "JWT audience")
+	flag.DurationVar(&cfg.jwt.accessTTL, "jwt-access-ttl", 15*time.Minute, "JWT lifetime")
+	flag.DurationVar(&cfg.jwt.refreshTTL, "jwt-refresh-ttl", 30*24*time.Hour, "JWT refresh token lifetime")
+
+	// Movie configuration
+	flag.DurationVar(&cfg.movies.retention, "movies-retention", 30*24*time.Hour, "Retention period for deleted movies (0 to keep them forever)")
+
+	// Storage configuration
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./storage", "Directory for uploaded files, such as movie posters")
+
+	// Cache configuration
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "How long users and permissions are cached for (0 to disable)")
 
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -121,6 +200,26 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	store, err := storage.NewFileSystem(cfg.storage.dir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	var keys *jwtkeys.Set
+	if cfg.jwt.keyDir != "" {
+		// A key has to keep verifying tokens until the last ones it signed have expired.
+		if cfg.jwt.keyGrace < cfg.jwt.accessTTL {
+			logger.PrintFatal(errors.New("-jwt-key-grace must not be shorter than -jwt-access-ttl"), nil)
+		}
+
+		keys, err = jwtkeys.Load(cfg.jwt.keyDir, cfg.jwt.keyGrace)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("JWT keys loaded", map[string]string{"signing_key": keys.SigningKeyID(time.Now())})
+	}
+
 	// Add the current version number to our debug output.
 	expvar.NewString("version").Set(version)
 
@@ -132,16 +231,31 @@ func main() {
 	expvar.Publish("database", expvar.Func(func() interface{} {
 		return db.Stats()
 	}))
+
+	models := data.NewModels(db, cfg.db.queryTimeout)
+
+	// Cache the user and permission lookups made on every authenticated request, and publish how effective the cache is.
+	if cfg.cache.ttl > 0 {
+		var caches *data.Caches
+		models, caches = data.WithCache(models, cfg.cache.ttl)
+		expvar.Publish("cache", expvar.Func(func() interface{} {
+			return caches.Stats()
+		}))
+	}
+
 	// Publish the current Unix timestamp.
 	expvar.Publish("timestamp", expvar.Func(func() interface{} {
 		return time.Now().Unix()
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  models,
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		jwtKeys: keys,
+		done:    make(chan struct{}),
 	}
 
 	if err = app.serve(); err != nil {
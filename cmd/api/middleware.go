@@ -1,16 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"expvar"
 	"fmt"
 	"github.com/ejacobg/greenlight/internal/data"
 	"github.com/ejacobg/greenlight/internal/validator"
 	"github.com/felixge/httpsnoop"
-	"github.com/pascaldekloe/jwt"
-	"github.com/tomasen/realip"
 	"golang.org/x/exp/slices"
 	"golang.org/x/time/rate"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -68,7 +70,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract the client's IP address from the request.
-		ip := realip.FromRequest(r)
+		ip := app.clientIP(r)
 
 		mu.Lock()
 
@@ -113,7 +115,12 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// If the Authorization header was given, confirm that its value is of the form: Bearer <token>
+		// API keys are also accepted, in the form: ApiKey <key>
 		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -130,7 +137,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Get the user associated with this token. Note that this token is being used for authentication, so set the scope appropriately.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -141,6 +148,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Record that the session is still in use. This only writes to the database if the token hasn't been touched recently.
+		// The request has already been authenticated, so a failure here is logged rather than returned.
+		err = app.models.Tokens.Touch(r.Context(), token)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
 		// Apply the *User value to the request context.
 		r = app.contextSetUser(r, user)
 
@@ -163,6 +177,10 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -170,8 +188,8 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 
 		token := headerParts[1]
 
-		// Parse the JWT and extract the claims. If the contents of the JWT do not match the signature, then this call will return an error.
-		claims, err := jwt.HMACCheck([]byte(token), []byte(app.config.jwt.secret))
+		// Parse the JWT and extract the claims. If the contents of the JWT do not match the signature, or the key named in its header is unknown or retired, then this call will return an error.
+		claims, err := app.jwtKeys.Check([]byte(token), time.Now())
 		if err != nil {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -184,13 +202,30 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 		}
 
 		// Check that the issuer is our application.
-		if claims.Issuer != "greenlight.ejacobg.com" {
+		if claims.Issuer != app.config.jwt.issuer {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
 		// Check that our application is in the expected audiences for the JWT.
-		if !claims.AcceptAudience("greenlight.ejacobg.com") {
+		if !claims.AcceptAudience(app.config.jwt.audience) {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Every JWT we issue has an ID, an issue time, and an expiry, so that it can be revoked. Reject any that don't.
+		if claims.ID == "" || claims.Issued == nil || claims.Expires == nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Check that the JWT hasn't been revoked, such as by the user logging out.
+		revoked, err := app.models.Revocations.Exists(r.Context(), claims.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if revoked {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
@@ -203,7 +238,7 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 		}
 
 		// Lookup the user record from the database.
-		user, err := app.models.Users.Get(userID)
+		user, err := app.models.Users.Get(r.Context(), userID)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -214,12 +249,55 @@ func (app *application) authenticateJWT(next http.Handler) http.Handler {
 			return
 		}
 
+		// Reject JWTs issued before the user last logged out of every session, or changed their password.
+		if claims.Issued.Time().Before(user.TokensValidAfter) {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
 		// Add the user record to the request context and continue as normal.
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey is used by both authenticate and authenticateJWT to attach the owner of an API key to the request.
+// The key is attached as well, so that hasPermission can limit the owner's permissions to those of the key.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	// API keys are generated in the same way as tokens, so they can be validated in the same way.
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	key, err := app.models.APIKeys.GetForPlaintext(r.Context(), plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), key.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
 // requireAuthenticatedUser checks if the user is anonymous. If they are, then a 401 Unauthorized response will be returned.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -234,6 +312,22 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	}
 }
 
+// requireSession restricts a handler to users who authenticated with a token rather than an API key.
+// It guards account management, so that an API key can't be used to create wider keys, or to take over its owner's account.
+// Since an API key's scope is only enforced by requirePermission, every authenticated route should use either requirePermission or requireSession.
+func (app *application) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 // requireActivatedUser will restrict access to a handler to only those requests that have a valid *User value attached to them.
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -257,18 +351,14 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 // requirePermission will protect access to a handler if the request context's *User value does not have the requisite permissions.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
-		user := app.contextGetUser(r)
-
-		// Get this user's permissions.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permitted, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
 		// If the user does not have the required permission, return 403 Forbidden.
-		if !permissions.Include(code) {
+		if !permitted {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -281,6 +371,177 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// hasPermission reports whether the user in the request context has been granted the given permission.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	// Retrieve the user from the request context.
+	user := app.contextGetUser(r)
+
+	// Get this user's permissions.
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// Requests made with an API key are limited to the permissions of the key, as well as those of its owner.
+	if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+		return false, nil
+	}
+
+	return permissions.Include(code), nil
+}
+
+// responseRecorder passes writes through to the underlying http.ResponseWriter while keeping a copy of the status code and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets an http.ResponseController reach the underlying http.ResponseWriter.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// idempotent will store the response to any request sent with an Idempotency-Key header, and replay that response if the request is retried with the same key.
+// Keys are scoped to the request context's *User value, so this middleware must run after authenticate or authenticateJWT.
+// Anonymous requests all share the same user, so their keys are scoped to the client's IP as well. Otherwise, anyone who guessed another client's key could replay its response.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+
+		// Requests without a key are processed as normal.
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			// IPs never contain spaces, so this can't be confused with another IP's key.
+			key = app.clientIP(r) + " " + key
+		}
+
+		// Read in the whole body so that it can be hashed, then put it back for the next handler to use.
+		// This uses the same size limit as readJSON.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// A retry must be for the same endpoint with the same body.
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		hash.Write(body)
+
+		record := &data.IdempotencyRecord{
+			UserID:      user.ID,
+			Key:         key,
+			RequestHash: hash.Sum(nil),
+			Expiry:      time.Now().Add(24 * time.Hour),
+		}
+
+		// Try to reserve the key. If it has already been used, replay the stored response instead.
+		err = app.models.Idempotency.Insert(r.Context(), record)
+		if err != nil {
+			if !errors.Is(err, data.ErrDuplicateIdempotencyKey) {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			existing, err := app.models.Idempotency.Get(r.Context(), record.UserID, key)
+			if err != nil {
+				switch {
+				// The record may have expired or been released in the meantime.
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.idempotencyConflictResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			switch {
+			case !bytes.Equal(existing.RequestHash, record.RequestHash):
+				v.AddError("idempotency_key", "has already been used for a different request")
+				app.failedValidationResponse(w, r, v.Errors)
+			case existing.InProgress():
+				app.idempotencyConflictResponse(w, r)
+			default:
+				for name, value := range existing.Header {
+					w.Header()[name] = value
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// Headers set by earlier middleware (e.g. CORS) depend on the retried request, so only the handler's own headers are saved.
+		before := w.Header().Clone()
+
+		// If the handler fails or panics, release the key so that the client can try again.
+		// The request context isn't used here, since the key must be released or completed even if the client has gone away.
+		completed := false
+		defer func() {
+			if !completed {
+				err := app.models.Idempotency.Delete(context.Background(), record.UserID, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		// Server errors are not saved, since a retry may succeed.
+		if rec.status >= 500 {
+			return
+		}
+
+		record.Status = rec.status
+		record.Header = make(http.Header)
+		for name, value := range w.Header() {
+			if !slices.Equal(before[name], value) {
+				record.Header[name] = value
+			}
+		}
+		record.Body = rec.body.Bytes()
+
+		err = app.models.Idempotency.Complete(context.Background(), record)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	}
+}
+
 // enableCORS will tell the browser to grant our trusted origins the ability to read our responses. This method will also respond to any preflight CORS requests.
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -298,11 +559,14 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			// If the origin is trusted, then set our CORS header appropriately.
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 
+			// Allow scripts to read the ETag header, so that they can make conditional requests.
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
 			// If this is an OPTIONS request with the Origin and Access-Control-Request-Method headers set, then treat this as a preflight request.
 			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 				// We will send the same preflight response headers for all preflight requests.
 				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match")
 
 				// End this request with a 200 OK response.
 				w.WriteHeader(http.StatusOK)
@@ -315,13 +579,17 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// These expvar variables are published once for the whole process, since expvar panics if the same name is published twice.
+// This lets the routes be built more than once, as the tests do.
+var (
+	totalRequestsReceived           = expvar.NewInt("total_requests_received")
+	totalResponsesSent              = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+	totalResponsesSentByStatus      = expvar.NewMap("total_responses_sent_by_status") // We will map an HTTP code to the number of times we've responded with it.
+)
+
 // metrics will record the number of requests and responses sent, as well as the total time (in microseconds) spent processing requests.
 func (app *application) metrics(next http.Handler) http.Handler {
-	// These expvar variables will be created when this handler is first attached to the middleware chain.
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
-	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status") // We will map an HTTP code to the number of times we've responded with it.
 
 	// This code will run for each request.
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
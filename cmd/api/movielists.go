@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"net/http"
+)
+
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	app.listMovieList(w, r, app.models.Watchlist)
+}
+
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	app.addToMovieList(w, r, app.models.Watchlist)
+}
+
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	app.removeFromMovieList(w, r, app.models.Watchlist)
+}
+
+func (app *application) listWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listMovieList(w, r, app.models.Watched)
+}
+
+func (app *application) addToWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	app.addToMovieList(w, r, app.models.Watched)
+}
+
+func (app *application) removeFromWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	app.removeFromMovieList(w, r, app.models.Watched)
+}
+
+// listMovieList sends a page of the movies on the current user's list, paginated in the same way as listMoviesHandler (but without cursors).
+func (app *application) listMovieList(w http.ResponseWriter, r *http.Request, list data.MovieListStore) {
+	var input data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Page = app.readInt(qs, "page", 1, v)
+	input.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// Lists are ordered by the most recently added movies first, unless asked otherwise.
+	input.Sort = app.readString(qs, "sort", "-added_at")
+	input.SortSafelist = []string{"added_at", "title", "year", "runtime", "rating", "-added_at", "-title", "-year", "-runtime", "-rating"}
+
+	if data.ValidateFilters(v, input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := list.GetAll(r.Context(), app.contextGetUser(r).ID, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addToMovieList puts the movie named by the id parameter on the current user's list. Adding a movie that is already on the list just updates when it was added.
+func (app *application) addToMovieList(w http.ResponseWriter, r *http.Request, list data.MovieListStore) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	addedAt, err := list.Add(r.Context(), app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie_id": movieID, "added_at": addedAt}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeFromMovieList(w http.ResponseWriter, r *http.Request, list data.MovieListStore) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = list.Remove(r.Context(), app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/ejacobg/greenlight/internal/data"
 	"github.com/ejacobg/greenlight/internal/validator"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -15,7 +17,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -26,7 +28,42 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	v := validator.New()
+
+	// Related records can be embedded in the movie with the include parameter.
+	include := app.readCSV(r.URL.Query(), "include", []string{})
+	for _, value := range include {
+		v.Check(validator.In(value, "credits"), "include", "must only contain credits")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	headers := make(http.Header)
+
+	if validator.In("credits", include...) {
+		movie.Credits, err = app.models.Credits.GetAllForMovie(r.Context(), movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		// Credits aren't covered by the version, so the ETag is only used when they aren't included.
+		etag := movieETag(movie)
+
+		// If the client already has this version of the movie, then there's no need to send it again.
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag, true) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		headers.Set("ETag", etag)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -53,15 +90,21 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
+	genres, err := app.models.Genres.GetLookup(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	v := validator.New()
 
 	// Return 422 if any error was applied.
-	if data.ValidateMovie(v, &movie); !v.Valid() {
+	if data.ValidateMovie(v, &movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(&movie)
+	err = app.models.Movies.Insert(r.Context(), &movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -70,6 +113,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Add a Location header to tell the client where to find the created movie.
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(&movie))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
@@ -85,7 +129,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Retrieve the requested movie from the database.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -96,6 +140,13 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// If the client sent an If-Match header, then only update the version of the movie that they expect.
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	// Read in user input.
 	// If the user omits a field, we can detect it since it will be nil.
 	var input struct {
@@ -129,16 +180,25 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		movie.Genres = input.Genres
 	}
 
+	genres, err := app.models.Genres.GetLookup(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// If validation checks pass, update the database record.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		// The movie changed after it was checked against the If-Match header.
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -147,13 +207,16 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// Uses very similar logic to showMovieHandler.
+// Uses very similar logic to showMovieHandler, and honours If-Match in the same way as updateMovieHandler.
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -161,11 +224,38 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// A version of zero deletes the movie regardless of its version.
+	var version int32
+
+	// If the client sent an If-Match header, then only delete the version of the movie that they expect.
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" {
+		movie, err := app.models.Movies.Get(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !etagMatches(ifMatch, movieETag(movie), false) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		version = movie.Version
+	}
+
+	err = app.models.Movies.Delete(r.Context(), id, version, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		// The movie changed after it was checked against the If-Match header.
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -179,21 +269,42 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title  string
-		Genres []string
-		data.Filters
+// restoreMovieHandler undoes the deletion of a movie, as long as it hasn't been purged yet.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
 	}
 
+	movie, err := app.models.Movies.Restore(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	v := validator.New()
 
 	// Obtain the query parameters from the request.
 	qs := r.URL.Query()
 
 	// Extract data from the query parameters, applying defaults when necessary.
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input := app.readMovieSearch(qs, v)
 
 	// Note that the readInt() function will modify the validator if an error occurs.
 	// Because the Filters type is embedded, we technically don't need to explicitly access it here.
@@ -201,23 +312,91 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "rating", "relevance", "-id", "-title", "-year", "-runtime", "-rating"}
+
+	// Cursors are returned in the metadata of a previous response. If one is given, then the page parameter is ignored.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	// Deleted movies are only listed for administrators.
+	input.IncludeDeleted = app.readBool(qs, "include_deleted", false, v)
 
 	// Apply our filter rules, then check for correctness.
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	if data.ValidateMovieFilters(v, input); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Grab all movies (and associated metadata) that pass the given filters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	if input.IncludeDeleted {
+		permitted, err := app.hasPermission(r, "movies:admin")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permitted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	// Search by the canonical form of each genre, so that aliases match the same movies.
+	genres, err := app.models.Genres.GetLookup(r.Context())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	input.CanonicalGenres(genres)
+
+	// Grab all movies (and associated metadata) that pass the given filters.
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			v.AddError("cursor", "must be a valid cursor")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readMovieSearch reads the search criteria shared by listMoviesHandler and exportMoviesHandler. The embedded Filters are left empty.
+func (app *application) readMovieSearch(qs url.Values, v *validator.Validator) data.MovieFilters {
+	var input data.MovieFilters
+
+	input.Title = app.readString(qs, "title", "")
+	input.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+
+	// Genres prefixed with '!' are excluded. The rest are matched according to the genres_match parameter.
+	var genres []string
+	for _, genre := range app.readCSV(qs, "genres", []string{}) {
+		if strings.HasPrefix(genre, "!") {
+			input.ExcludeGenres = append(input.ExcludeGenres, strings.TrimPrefix(genre, "!"))
+		} else {
+			genres = append(genres, genre)
+		}
+	}
+
+	switch app.readString(qs, "genres_match", "all") {
+	case "all":
+		input.Genres = genres
+	case "any":
+		input.AnyGenres = genres
+	default:
+		v.AddError("genres_match", "must be either all or any")
+	}
+
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+	input.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+
+	return input
+}
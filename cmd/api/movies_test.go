@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const testMovieBody = `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"]}`
+
+func TestMovieLifecycle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	token := newTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	code, header, body := ts.request(t, http.MethodPost, "/v1/movies", token, testMovieBody)
+	if code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d: %s", code, http.StatusCreated, body)
+	}
+	if location := header.Get("Location"); location != "/v1/movies/1" {
+		t.Fatalf("create: got Location %q; want /v1/movies/1", location)
+	}
+
+	code, header, _ = ts.request(t, http.MethodGet, "/v1/movies/1", token, "")
+	if code != http.StatusOK {
+		t.Fatalf("show: got status %d; want %d", code, http.StatusOK)
+	}
+	etag := header.Get("ETag")
+
+	code, _, _ = ts.request(t, http.MethodGet, "/v1/movies/1", token, "", "If-None-Match", etag)
+	if code != http.StatusNotModified {
+		t.Fatalf("show with a matching If-None-Match: got status %d; want %d", code, http.StatusNotModified)
+	}
+
+	code, _, body = ts.request(t, http.MethodPatch, "/v1/movies/1", token, `{"year": 2017}`, "If-Match", etag)
+	if code != http.StatusOK {
+		t.Fatalf("update: got status %d; want %d: %s", code, http.StatusOK, body)
+	}
+
+	// The update changed the version, so the old ETag no longer matches.
+	code, _, _ = ts.request(t, http.MethodPatch, "/v1/movies/1", token, `{"year": 2018}`, "If-Match", etag)
+	if code != http.StatusPreconditionFailed {
+		t.Fatalf("update with a stale If-Match: got status %d; want %d", code, http.StatusPreconditionFailed)
+	}
+
+	code, _, _ = ts.request(t, http.MethodDelete, "/v1/movies/1", token, "")
+	if code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d", code, http.StatusOK)
+	}
+
+	code, _, _ = ts.request(t, http.MethodGet, "/v1/movies/1", token, "")
+	if code != http.StatusNotFound {
+		t.Fatalf("show after delete: got status %d; want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestMoviePermissions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	reader := newTestUser(t, app, "alice@example.com", "movies:read")
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		want   int
+	}{
+		{"anonymous read", http.MethodGet, "", "", http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", "", http.StatusUnauthorized},
+		{"read", http.MethodGet, reader, "", http.StatusOK},
+		{"write without movies:write", http.MethodPost, reader, testMovieBody, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.request(t, tt.method, "/v1/movies", tt.token, tt.body)
+			if code != tt.want {
+				t.Errorf("got status %d; want %d: %s", code, tt.want, body)
+			}
+		})
+	}
+}
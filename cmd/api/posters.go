@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/storage"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers the GIF decoder.
+	"image/jpeg"
+	_ "image/png" // Registers the PNG decoder.
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// posterMaxBytes limits the size of an uploaded poster. Like importMaxBytes, this is larger than the limit in readJSON.
+	posterMaxBytes = 10 << 20
+
+	// posterMaxPixels limits the dimensions of an uploaded poster, since a small file can still decode into a huge image.
+	posterMaxPixels = 50_000_000
+
+	// posterThumbnailWidth is the width of poster thumbnails in pixels. Their height keeps the poster's aspect ratio.
+	posterThumbnailWidth = 300
+)
+
+// posterTypes lists the content types accepted for posters. These are the types that the standard library can decode.
+var posterTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// posterKey returns the storage key of the given size ("original" or "thumbnail") of a version of a movie's poster.
+// Each version has its own keys, so the image behind a versioned poster_url never changes once clients may have cached it.
+func posterKey(movieID int64, version int32, size string) string {
+	return fmt.Sprintf("%s/%d/%s", posterPrefix(movieID), version, size)
+}
+
+// posterPrefix returns the storage key prefix shared by every version of a movie's poster.
+func posterPrefix(movieID int64) string {
+	return fmt.Sprintf("posters/%d", movieID)
+}
+
+// readPoster reads the image from a poster upload. The image may either be the whole body, or the "poster" field of a multipart/form-data body.
+func (app *application) readPoster(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	tooLarge := fmt.Errorf("poster must not be larger than %d bytes", posterMaxBytes)
+
+	// Leave some room for the multipart headers and boundaries.
+	r.Body = http.MaxBytesReader(w, r.Body, posterMaxBytes+1<<16)
+
+	var src io.Reader = r.Body
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip over any other fields until the poster is found.
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New(`body must contain a "poster" field`)
+			}
+			if err != nil {
+				var maxBytesError *http.MaxBytesError
+				if errors.As(err, &maxBytesError) {
+					return nil, tooLarge
+				}
+				return nil, err
+			}
+
+			if part.FormName() == "poster" {
+				src = part
+				break
+			}
+		}
+	}
+
+	// Read one byte past the limit, to tell whether the poster is too large.
+	poster, err := io.ReadAll(io.LimitReader(src, posterMaxBytes+1))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return nil, tooLarge
+		}
+		return nil, err
+	}
+
+	switch {
+	case len(poster) == 0:
+		return nil, errors.New("poster must not be empty")
+	case len(poster) > posterMaxBytes:
+		return nil, tooLarge
+	}
+
+	return poster, nil
+}
+
+// thumbnail scales the image down to the given width, keeping its aspect ratio, by averaging each block of source pixels.
+// Transparent areas are flattened onto white, since thumbnails are encoded as JPEG. Images that are already narrow enough are only flattened.
+func thumbnail(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// The colors are alpha-premultiplied, so they can be averaged directly.
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			r, g, b, a = r/n, g/n, b/n, a/n
+
+			// Adding the uncovered fraction of white to each premultiplied color flattens it.
+			white := 0xffff - a
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r + white), G: uint16(g + white), B: uint16(b + white), A: 0xffff})
+		}
+	}
+
+	return dst
+}
+
+// updatePosterHandler replaces a movie's poster with the uploaded image, which may be sent as the whole body or as a multipart form.
+// The content type is checked by sniffing the image itself, rather than trusting the client, and a thumbnail is generated alongside the original.
+func (app *application) updatePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	poster, err := app.readPoster(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(poster)
+	if !validator.In(contentType, posterTypes...) {
+		app.unsupportedMediaTypeResponse(w, r, posterTypes...)
+		return
+	}
+
+	v := validator.New()
+
+	// Check the dimensions before decoding, so that oversized images are rejected without allocating them.
+	config, _, err := image.DecodeConfig(bytes.NewReader(poster))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	v.Check(config.Width > 0 && config.Height > 0, "poster", "must not be empty")
+	v.Check(config.Width*config.Height <= posterMaxPixels, "poster", fmt.Sprintf("must not have more than %d pixels", posterMaxPixels))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(poster))
+	if err != nil {
+		v.AddError("poster", "must be a valid image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var thumb bytes.Buffer
+	err = jpeg.Encode(&thumb, thumbnail(img, posterThumbnailWidth), &jpeg.Options{Quality: 85})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Write the next version alongside the current one, which keeps being served until the movie is updated to point at the new version.
+	// If the update fails, then the movie still points at the current version, and the next upload will overwrite the unused objects.
+	oldVersion := movie.PosterVersion
+
+	err = app.storage.Put(r.Context(), posterKey(movie.ID, oldVersion+1, "original"), bytes.NewReader(poster))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storage.Put(r.Context(), posterKey(movie.ID, oldVersion+1, "thumbnail"), &thumb)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie.PosterType = contentType
+
+	err = app.models.Movies.UpdatePoster(r.Context(), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The old version is no longer referred to, so failing to delete it only leaves it behind until the movie is purged.
+	err = app.deletePoster(r.Context(), movie.ID, oldVersion)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showPosterHandler serves a movie's poster, or its thumbnail if the size parameter is "thumbnail".
+// Requests for the versioned poster_url of a movie can be cached indefinitely, since a new poster gets a new URL. Other requests must be revalidated using the ETag.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	size := app.readString(qs, "size", "original")
+	v.Check(validator.In(size, "original", "thumbnail"), "size", "must be either original or thumbnail")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterURL() == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	object, err := app.storage.Get(r.Context(), posterKey(movie.ID, movie.PosterVersion, size))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Close()
+
+	contentType := movie.PosterType
+	if size == "thumbnail" {
+		contentType = "image/jpeg"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"poster-%d-%d-%s"`, movie.ID, movie.PosterVersion, size))
+
+	// Posters are only served to clients with the movies:read permission, so they mustn't be stored by shared caches.
+	if qs.Get("v") == strconv.FormatInt(int64(movie.PosterVersion), 10) {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	// ServeContent takes care of If-None-Match, If-Modified-Since, and Range requests.
+	http.ServeContent(w, r, "", *movie.PosterUpdatedAt, object)
+}
+
+// deletePosterHandler removes a movie's poster.
+func (app *application) deletePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterURL() == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	oldVersion := movie.PosterVersion
+	movie.PosterType = ""
+
+	err = app.models.Movies.UpdatePoster(r.Context(), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.deletePoster(r.Context(), movie.ID, oldVersion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "poster successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deletePoster removes every size of a version of a movie's poster from storage.
+func (app *application) deletePoster(ctx context.Context, movieID int64, version int32) error {
+	for _, size := range []string{"original", "thumbnail"} {
+		err := app.storage.Delete(ctx, posterKey(movieID, version, size))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
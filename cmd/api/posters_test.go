@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/ejacobg/greenlight/internal/storage"
+)
+
+// testPoster returns a PNG image filled with the given color.
+func testPoster(t *testing.T, c color.Color) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestPosterVersions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	token := newTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	code, _, body := ts.request(t, http.MethodPost, "/v1/movies", token, testMovieBody)
+	if code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d: %s", code, http.StatusCreated, body)
+	}
+
+	red, blue := testPoster(t, color.RGBA{R: 255, A: 255}), testPoster(t, color.RGBA{B: 255, A: 255})
+
+	for i, poster := range []string{red, blue} {
+		code, _, body := ts.request(t, http.MethodPut, "/v1/movies/1/poster", token, poster, "Content-Type", "image/png")
+		if code != http.StatusOK {
+			t.Fatalf("upload %d: got status %d; want %d: %s", i+1, code, http.StatusOK, body)
+		}
+	}
+
+	// Only the current version is kept, and each version is stored under its own key.
+	if _, err := app.storage.Get(context.Background(), posterKey(1, 1, "original")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got %v for the replaced version; want storage.ErrNotFound", err)
+	}
+
+	code, header, body := ts.request(t, http.MethodGet, "/v1/movies/1/poster?v=2", token, "")
+	if code != http.StatusOK || body != blue {
+		t.Fatalf("current version: got status %d and %d bytes; want %d and the second poster", code, len(body), http.StatusOK)
+	}
+	if cc := header.Get("Cache-Control"); cc != "private, max-age=31536000, immutable" {
+		t.Errorf("current version: got Cache-Control %q", cc)
+	}
+
+	// A stale URL is still served, but mustn't be cached, since it no longer refers to the image behind it.
+	code, header, _ = ts.request(t, http.MethodGet, "/v1/movies/1/poster?v=1", token, "")
+	if code != http.StatusOK || header.Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("old version: got status %d and Cache-Control %q", code, header.Get("Cache-Control"))
+	}
+
+	code, _, _ = ts.request(t, http.MethodDelete, "/v1/movies/1/poster", token, "")
+	if code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d", code, http.StatusOK)
+	}
+	if _, err := app.storage.Get(context.Background(), posterKey(1, 2, "thumbnail")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got %v for the deleted poster; want storage.ErrNotFound", err)
+	}
+}
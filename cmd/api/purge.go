@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// moviePurgeInterval is how often deleted movies are checked against the retention period.
+const moviePurgeInterval = time.Hour
+
+// purgeDeletedMovies permanently removes movies that were deleted more than app.config.movies.retention ago.
+// It runs once on startup, then once per moviePurgeInterval until app.done is closed.
+func (app *application) purgeDeletedMovies() {
+	ticker := time.NewTicker(moviePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-app.config.movies.retention)
+
+		purged, err := app.models.Movies.Purge(context.Background(), before)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		} else if len(purged) > 0 {
+			app.logger.PrintInfo("purged deleted movies", map[string]string{
+				"count": strconv.Itoa(len(purged)),
+			})
+		}
+
+		// The posters of purged movies aren't needed any more. This includes any old versions that couldn't be deleted when they were replaced.
+		for _, id := range purged {
+			err := app.storage.DeleteAll(context.Background(), posterPrefix(id))
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"movie_id": strconv.FormatInt(id, 10)})
+			}
+		}
+
+		select {
+		case <-app.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"net/http"
+)
+
+// checkRevisionsVisible reports whether the caller may see the revisions of the movie with the given ID.
+// Deleted movies are hidden from everyone but administrators, as in listMoviesHandler. If the revisions can't be seen, an error response is sent and false is returned.
+func (app *application) checkRevisionsVisible(w http.ResponseWriter, r *http.Request, id int64) bool {
+	_, err := app.models.Movies.Get(r.Context(), id)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	// The movie either doesn't exist, or has been deleted. Revisions are kept until a deleted movie is purged, so administrators can still see them.
+	permitted, err := app.hasPermission(r, "movies:admin")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !permitted {
+		app.notFoundResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// listMovieRevisionsHandler returns every saved version of a movie, newest first.
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.checkRevisionsVisible(w, r, id) {
+		return
+	}
+
+	revisions, err := app.models.Revisions.GetAllForMovie(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Every movie has at least one revision, so an empty history means that the movie doesn't exist.
+	if len(revisions) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.checkRevisionsVisible(w, r, id) {
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffMovieRevisionsHandler lists the fields that changed between the versions given by the from and to query parameters.
+func (app *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	from := app.readInt(qs, "from", 0, v)
+	to := app.readInt(qs, "to", 0, v)
+
+	v.Check(from > 0, "from", "must be a positive version number")
+	v.Check(to > 0, "to", "must be a positive version number")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkRevisionsVisible(w, r, id) {
+		return
+	}
+
+	revisions := make([]*data.MovieRevision, 2)
+	for i, version := range []int{from, to} {
+		revisions[i], err = app.models.Revisions.Get(r.Context(), id, int32(version))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	diff := envelope{
+		"movie_id": id,
+		"from":     from,
+		"to":       to,
+		"changes":  data.DiffRevisions(revisions[0], revisions[1]),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovieHandler saves a new version of a movie, with the same title, year, runtime, and genres as an earlier version.
+// The update goes through the same optimistic locking (and If-Match handling) as updateMovieHandler. Whether the movie is deleted isn't reverted, since restoreMovieHandler handles that.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && !etagMatches(ifMatch, movieETag(movie), false) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.Title = revision.Title
+	movie.Year = revision.Year
+	movie.Runtime = revision.Runtime
+	movie.Genres = revision.Genres
+
+	genres, err := app.models.Genres.GetLookup(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The revision was valid when it was saved, but the validation rules (and genres) may have changed since then.
+	v := validator.New()
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && ifMatch != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -16,26 +16,100 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
+	// Every POST route accepts an Idempotency-Key, except for:
+	//  - /v1/movies/import, since its body may be much larger than the limit that idempotent reads in to hash.
+	//  - /v1/users/me/api-keys, /v1/tokens/authentication and /v1/tokens/refresh, since their responses hold plaintext keys or tokens, which must never be stored.
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.dispatchParam("id", app.methodNotAllowedResponse, map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.importMoviesHandler),
+	}))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.dispatchParam("id", app.showMovieHandler, map[string]http.HandlerFunc{
+		"export": app.exportMoviesHandler,
+	})))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/poster", app.requirePermission("movies:read", app.showPosterHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.updatePosterHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.deletePosterHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.idempotent(app.restoreMovieHandler)))
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.dispatchParam("version", app.showMovieRevisionHandler, map[string]http.HandlerFunc{
+		"diff": app.diffMovieRevisionsHandler,
+	})))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.idempotent(app.revertMovieHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.idempotent(app.createReviewHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/:id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/reviews/:id", app.requirePermission("movies:read", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/reviews/:id", app.requirePermission("movies:read", app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.idempotent(app.createCreditHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/credits/:id", app.requirePermission("movies:read", app.showCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/credits/:id", app.requirePermission("movies:write", app.deleteCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.idempotent(app.createPersonHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:admin", app.idempotent(app.createGenreHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("movies:admin", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres/:id/merge", app.requirePermission("movies:admin", app.idempotent(app.mergeGenreHandler)))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireSession(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSession(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSession(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireSession(app.updateCurrentUserPasswordHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSession(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireSession(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSession(app.deleteAPIKeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requirePermission("movies:read", app.listWatchedHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watched/:id", app.requirePermission("movies:read", app.addToWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id", app.requirePermission("movies:read", app.removeFromWatchedHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.listUserRolesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.addUserRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("users:admin", app.removeUserRoleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.addUserPermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.removeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/roles/:role/permissions/:code", app.requirePermission("users:admin", app.addRolePermissionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:role/permissions/:code", app.requirePermission("users:admin", app.removeRolePermissionHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.idempotent(app.createActivationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.idempotent(app.createPasswordResetTokenHandler))
 
-	// If a JWT secret is being used, then use JWT authentication.
-	if app.config.jwt.secret != "" {
+	// If JWT keys were loaded, then use JWT authentication.
+	if app.jwtKeys != nil {
+		router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
 		router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createJWTHandler)
+		router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshJWTHandler)
+		router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireSession(app.deleteJWTHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireSession(app.deleteAllJWTsHandler))
 		return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticateJWT(router)))))
 	} else {
 		// 	Otherwise, use stateful tokens.
 		router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+		router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireSession(app.deleteAuthenticationTokenHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireSession(app.deleteAllAuthenticationTokensHandler))
+		router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireSession(app.listSessionsHandler))
+		router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireSession(app.deleteSessionHandler))
 		return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
 	}
 }
@@ -54,12 +54,19 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		// Tell any long-running tasks to stop, so that they can be waited on as well.
+		close(app.done)
 		app.wg.Wait()
 
 		// Once all tasks have finish, continue with the shutdown.
 		shutdownError <- nil
 	}()
 
+	// Periodically purge deleted movies, unless they are to be kept forever.
+	if app.config.movies.retention > 0 {
+		app.background(app.purgeDeletedMovies)
+	}
+
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ejacobg/greenlight/internal/data"
+	"github.com/ejacobg/greenlight/internal/data/memstore"
+	"github.com/ejacobg/greenlight/internal/jsonlog"
+	"github.com/ejacobg/greenlight/internal/storage"
+)
+
+// newTestApplication returns an application backed by an in-memory store, which discards its logs.
+// It has no mailer, so tests mustn't use the handlers that send emails.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	store, err := storage.NewFileSystem(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		logger:  jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models:  memstore.NewModels(),
+		storage: store,
+	}
+}
+
+// testServer wraps an httptest.Server that is closed when the test finishes.
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// request sends a request with a JSON body, authenticated with the token if it isn't empty. The headers are given as name and value pairs.
+// It returns the response's status code, headers, and body.
+func (ts *testServer) request(t *testing.T, method, path, token, body string, headers ...string) (int, http.Header, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, res.Header, string(resBody)
+}
+
+// newTestUser inserts an activated user with the given permissions, and returns an authentication token for them.
+func newTestUser(t *testing.T, app *application, email string, permissions ...string) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: true}
+	if err := user.Password.Set("pa55word1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		if err := app.models.Permissions.AddForUser(ctx, user.ID, permissions...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
@@ -4,62 +4,32 @@ import (
 	"errors"
 	"github.com/ejacobg/greenlight/internal/data"
 	"github.com/ejacobg/greenlight/internal/validator"
-	"github.com/pascaldekloe/jwt"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 )
 
 // createAuthenticationTokenHandler will confirm that the request's email and password match a specific user, and if so, will create and respond with an authentication token.
 // Tokens created through this handler should be authenticated with the authenticate middleware.
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-
-	err := app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// Confirm that the request's email and password match a user.
+	user := app.checkCredentials(w, r)
+	if user == nil {
 		return
 	}
 
-	// Validate the email and password provided by the client.
-	v := validator.New()
-	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	// Check if the provided email exists, returning an error if we can't find it.
-	user, err := app.models.Users.GetByEmail(input.Email)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-
-	// Confirm that the provided password matches what's in the database.
-	match, err := user.Password.Matches(input.Password)
+	// If the password is correct, generate a 24-hour authentication token.
+	token, err := data.GenerateToken(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// If the passwords don't match, return an error.
-	if !match {
-		app.invalidCredentialsResponse(w, r)
-		return
-	}
+	// Record where the token is being used from, so that the user can recognise this session later.
+	token.UserAgent = r.UserAgent()
+	token.IP = app.clientIP(r)
 
-	// If the password is correct, generate a 24-hour authentication token.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	err = app.models.Tokens.Insert(r.Context(), token)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -76,71 +46,31 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 // Tokens created through this handler should be authenticated with the authenticateJWT middleware.
 func (app *application) createJWTHandler(w http.ResponseWriter, r *http.Request) {
 	// This user validation is the same as that in createAuthenticationTokenHandler.
-	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-
-	err := app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	user := app.checkCredentials(w, r)
+	if user == nil {
 		return
 	}
 
-	v := validator.New()
-	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// Each login starts a new family of refresh tokens.
+	family, err := data.NewTokenFamily()
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	match, err := user.Password.Matches(input.Password)
+	refreshToken, err := app.newRefreshToken(r, user.ID, family, time.Now().Add(app.config.jwt.refreshTTL))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if !match {
-		app.invalidCredentialsResponse(w, r)
-		return
-	}
-
-	// Create a JWT claims struct to hold the information we will encode into our token.
-	var claims jwt.Claims
-
-	// Like our stateful token, we will store the user's ID.
-	// The Subject field is a string, so we have to convert the user ID.
-	claims.Subject = strconv.FormatInt(user.ID, 10)
-
-	// This JWT will be valid for the next 24 hours.
-	claims.Issued = jwt.NewNumericTime(time.Now())
-	claims.NotBefore = jwt.NewNumericTime(time.Now())
-	claims.Expires = jwt.NewNumericTime(time.Now().Add(24 * time.Hour))
 
-	// The Issuer and Audiences should be a unique value for our application.
-	claims.Issuer = "greenlight.ejacobg.com"
-	claims.Audiences = []string{"greenlight.ejacobg.com"}
-
-	// Sign our claims using the JWT secret passed in from the command line.
-	jwtBytes, err := claims.HMACSign(jwt.HS256, []byte(app.config.jwt.secret))
+	accessToken, err := app.newJWT(user.ID, family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Convert the byte slice to a string and return it in a JSON response.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": string(jwtBytes)}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": accessToken, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -165,7 +95,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	}
 
 	// Use the given email to find the user. If the associated user does not exist, return an error.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -185,7 +115,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	}
 
 	// If the user is activated, create a 45-minute password reset token, and associate it with the user.
-	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -232,7 +162,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 
 	// Use the given email to find the user. If the associated user does not exist, return an error.
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -252,7 +182,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 
 	// Generate the new activation token for the inactivated user.
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -278,3 +208,43 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// deleteAuthenticationTokenHandler logs the user out by deleting the authentication token used to make the request.
+// This handler is only routed when stateful tokens are in use, since a JWT can't be revoked.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// The authenticate middleware has already checked that the header is of the form: Bearer <token>
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	err := app.models.Tokens.Delete(r.Context(), data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		// The token may have been deleted by a concurrent request.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler logs the user out of every session by deleting all of their authentication tokens.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
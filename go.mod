@@ -1,6 +1,6 @@
 module github.com/ejacobg/greenlight
 
-go 1.19
+go 1.20
 
 require (
 	github.com/felixge/httpsnoop v1.0.2
@@ -8,7 +8,6 @@ require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.2
 	github.com/pascaldekloe/jwt v1.12.0
-	github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce
 	golang.org/x/crypto v0.6.0
 	golang.org/x/exp v0.0.0-20230307190834-24139beb5833
 	golang.org/x/time v0.3.0
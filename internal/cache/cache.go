@@ -0,0 +1,220 @@
+// Package cache provides a simple in-process cache whose entries expire after a fixed time-to-live.
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache maps keys to values for up to a fixed duration. It is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[K]entry[V]
+	lastSweep time.Time
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Stats reports how well a cache is performing.
+type Stats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"` // Includes expired entries that haven't been removed yet.
+}
+
+// New returns an empty cache whose entries expire ttl after they are set.
+func New[K comparable, V any](ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:       ttl,
+		entries:   make(map[K]entry[V]),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the value for key, and whether an unexpired value was found.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && !time.Now().Before(e.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		c.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+
+	c.hits.Add(1)
+	return e.value, true
+}
+
+// Set stores the value for key, replacing any existing value.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value)
+}
+
+// SetIf stores the value for key, but only if ok returns true. It reports whether the value was stored.
+// ok is called while the cache is locked, so a Delete or DeleteFunc can't run between the check and the store. See Generations.
+func (c *Cache[K, V]) SetIf(key K, value V, ok func() bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !ok() {
+		return false
+	}
+
+	c.set(key, value)
+	return true
+}
+
+// set stores the value for key. The caller must hold c.mu.
+func (c *Cache[K, V]) set(key K, value V) {
+	now := time.Now()
+
+	// Entries that are never read again would otherwise stay in memory forever, so expired entries are swept out once per TTL.
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.entries[key] = entry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// Delete removes the value for key, if there is one.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// DeleteFunc removes every entry for which fn returns true.
+func (c *Cache[K, V]) DeleteFunc(fn func(K, V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.entries {
+		if fn(k, e.value) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Stats returns the cache's hit and miss counts, and its current size.
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// Generations records when each key was last invalidated, so that a value loaded before an invalidation isn't cached after it.
+//
+// A loader calls Current before reading from the source, then stores its result with Cache.SetIf, checking Unchanged.
+// An invalidation calls Invalidate before deleting the cached entries.
+// Either the loader's value is stored before the entries are deleted, or the loader sees the invalidation and doesn't store it.
+type Generations[K comparable] struct {
+	window time.Duration
+
+	mu          sync.Mutex
+	current     uint64
+	invalidated map[K]invalidation
+	forgotten   uint64 // Loads that started before this generation are never unchanged, since their invalidations have been forgotten (or every key was invalidated).
+	lastSweep   time.Time
+}
+
+type invalidation struct {
+	gen uint64
+	at  time.Time
+}
+
+// NewGenerations returns a Generations in which no key has been invalidated.
+// Invalidations are forgotten once they are older than window, after which loads that started before them are never reported as unchanged.
+func NewGenerations[K comparable](window time.Duration) *Generations[K] {
+	return &Generations[K]{
+		window:      window,
+		invalidated: make(map[K]invalidation),
+		lastSweep:   time.Now(),
+	}
+}
+
+// Current returns the current generation.
+func (g *Generations[K]) Current() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.current
+}
+
+// Invalidate starts a new generation, and records that key was invalidated in it.
+func (g *Generations[K]) Invalidate(key K) {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Like the cache's entries, old invalidations are swept out once per window so that they don't build up forever.
+	if now.Sub(g.lastSweep) >= g.window {
+		for k, inv := range g.invalidated {
+			if now.Sub(inv.at) >= g.window {
+				delete(g.invalidated, k)
+				if inv.gen > g.forgotten {
+					g.forgotten = inv.gen
+				}
+			}
+		}
+		g.lastSweep = now
+	}
+
+	g.current++
+	g.invalidated[key] = invalidation{gen: g.current, at: now}
+}
+
+// InvalidateAll starts a new generation in which every key is invalidated.
+func (g *Generations[K]) InvalidateAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.current++
+	g.forgotten = g.current
+
+	// Every earlier invalidation is covered by this one.
+	g.invalidated = make(map[K]invalidation)
+}
+
+// Unchanged reports whether key has not been invalidated since gen was the current generation.
+func (g *Generations[K]) Unchanged(key K, gen uint64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// The key may have been invalidated and then forgotten since gen, so it can't be known to be unchanged.
+	if gen < g.forgotten {
+		return false
+	}
+
+	return g.invalidated[key].gen <= gen
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheGetSet(t *testing.T) {
+	c := New[string, int](time.Minute)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a value from an empty cache")
+	}
+
+	c.Set("a", 1)
+	c.Set("a", 2)
+	if v, ok := c.Get("a"); !ok || v != 2 {
+		t.Fatalf("got %d, %t; want 2, true", v, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("got %+v; want 1 hit, 1 miss, and 1 entry", stats)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	c := New[string, int](10 * time.Millisecond)
+
+	c.Set("a", 1)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got an expired value")
+	}
+
+	// Setting another key sweeps out entries that are never read again.
+	c.Set("b", 1)
+	time.Sleep(20 * time.Millisecond)
+	c.Set("c", 1)
+	if entries := c.Stats().Entries; entries != 1 {
+		t.Fatalf("got %d entries; want 1", entries)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	c := New[string, int](time.Minute)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+
+	c.Delete("a")
+	c.DeleteFunc(func(_ string, v int) bool { return v == 2 })
+
+	for key, want := range map[string]bool{"a": false, "b": false, "c": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s: got found %t; want %t", key, ok, want)
+		}
+	}
+}
+
+func TestCacheSetIf(t *testing.T) {
+	c := New[string, int](time.Minute)
+
+	if c.SetIf("a", 1, func() bool { return false }) {
+		t.Fatal("stored a value when ok returned false")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a value that shouldn't have been stored")
+	}
+
+	if !c.SetIf("a", 1, func() bool { return true }) {
+		t.Fatal("didn't store a value when ok returned true")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("got %d, %t; want 1, true", v, ok)
+	}
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+	c := New[int, int](time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Set(j, i)
+				c.Get(j)
+				c.SetIf(j, i, func() bool { return true })
+				c.DeleteFunc(func(k, _ int) bool { return k == j })
+				c.Delete(j)
+				c.Stats()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestGenerations(t *testing.T) {
+	g := NewGenerations[string](time.Minute)
+
+	gen := g.Current()
+	if !g.Unchanged("a", gen) {
+		t.Fatal("a key that was never invalidated was changed")
+	}
+
+	g.Invalidate("a")
+	if g.Unchanged("a", gen) {
+		t.Fatal("an invalidated key was unchanged")
+	}
+	if !g.Unchanged("b", gen) {
+		t.Fatal("invalidating one key changed another")
+	}
+	if !g.Unchanged("a", g.Current()) {
+		t.Fatal("a key was changed by an invalidation that came before the load")
+	}
+}
+
+func TestGenerationsInvalidateAll(t *testing.T) {
+	g := NewGenerations[string](time.Minute)
+
+	gen := g.Current()
+	g.InvalidateAll()
+	if g.Unchanged("a", gen) {
+		t.Fatal("a key was unchanged after every key was invalidated")
+	}
+	if !g.Unchanged("a", g.Current()) {
+		t.Fatal("a load that started after the invalidation was changed")
+	}
+}
+
+func TestGenerationsForgotten(t *testing.T) {
+	g := NewGenerations[string](10 * time.Millisecond)
+
+	gen := g.Current()
+	g.Invalidate("a")
+	time.Sleep(20 * time.Millisecond)
+
+	// This sweeps out the invalidation of "a", so a load that started before it can no longer be trusted.
+	g.Invalidate("b")
+	if g.Unchanged("a", gen) {
+		t.Fatal("a forgotten invalidation was treated as unchanged")
+	}
+	if !g.Unchanged("a", g.Current()) {
+		t.Fatal("a load that started after the sweep was changed")
+	}
+}
+
+// TestLoadRacingInvalidation checks that a value loaded before an invalidation can't be stored after it.
+// This is the sequence of a request reading a session from the database while the session is revoked.
+func TestLoadRacingInvalidation(t *testing.T) {
+	c := New[string, int](time.Minute)
+	g := NewGenerations[string](time.Minute)
+
+	// The load starts, and reads the value from the source.
+	gen := g.Current()
+	loaded := 1
+
+	// Meanwhile, the source is changed and the key is invalidated.
+	g.Invalidate("a")
+	c.Delete("a")
+
+	// The load finishes, and tries to cache what it read.
+	stored := c.SetIf("a", loaded, func() bool { return g.Unchanged("a", gen) })
+	if stored {
+		t.Fatal("stored a value loaded before the invalidation")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("got a value loaded before the invalidation")
+	}
+}
+
+func TestLoadRacingInvalidationConcurrently(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		c := New[string, int](time.Minute)
+		g := NewGenerations[string](time.Minute)
+
+		// The source holds version 1 until it is invalidated, after which it holds version 2.
+		var (
+			mu     sync.Mutex
+			source = 1
+		)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			gen := g.Current()
+			mu.Lock()
+			loaded := source
+			mu.Unlock()
+			c.SetIf("a", loaded, func() bool { return g.Unchanged("a", gen) })
+		}()
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			source = 2
+			mu.Unlock()
+			g.Invalidate("a")
+			c.Delete("a")
+		}()
+		wg.Wait()
+
+		if v, ok := c.Get("a"); ok && v != 2 {
+			t.Fatalf("got stale value %d after the invalidation", v)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"time"
+)
+
+// APIKey lets a machine client act on behalf of its owner, limited to a subset of the owner's permissions.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"-"`
+	Plaintext   string      `json:"key,omitempty"` // Only known when the key is created.
+	Hash        []byte      `json:"-"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// GenerateAPIKey creates a new random key for the given user. The key is not saved anywhere.
+// Keys are generated and hashed in the same way as tokens.
+func GenerateAPIKey(userID int64, name string, permissions Permissions) (*APIKey, error) {
+	plaintext, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return &APIKey{
+		UserID:      userID,
+		Plaintext:   plaintext,
+		Hash:        hash[:],
+		Name:        name,
+		Permissions: permissions,
+	}, nil
+}
+
+// ValidateAPIKey checks the key's name and permissions. A key can only be given permissions that its owner already has.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, owner Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(key.Permissions != nil, "permissions", "must be provided")
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, code := range key.Permissions {
+		if !owner.Include(code) {
+			v.AddError("permissions", "must only contain permissions that you have")
+			break
+		}
+	}
+}
+
+type APIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+func (m APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	query := `
+INSERT INTO api_keys (user_id, hash, name, permissions)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at`
+
+	args := []interface{}{key.UserID, key.Hash, key.Name, pq.Array(key.Permissions)}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	return queryError(ctx, err)
+}
+
+// GetForPlaintext returns the key matching the given plaintext.
+func (m APIKeyModel) GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, error) {
+	query := `
+SELECT id, created_at, user_id, hash, name, permissions
+FROM api_keys
+WHERE hash = $1`
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var key APIKey
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Hash,
+		&key.Name,
+		pq.Array(&key.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &key, nil
+}
+
+// GetAllForUser returns the user's keys, oldest first. The plaintext of each key is not available.
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+SELECT id, created_at, user_id, hash, name, permissions
+FROM api_keys
+WHERE user_id = $1
+ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Hash,
+			&key.Name,
+			pq.Array(&key.Permissions),
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+// Delete revokes one of the user's keys. ErrRecordNotFound is returned if the key doesn't exist or belongs to another user.
+func (m APIKeyModel) Delete(ctx context.Context, userID, id int64) error {
+	query := `
+DELETE FROM api_keys
+WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
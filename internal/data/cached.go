@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/ejacobg/greenlight/internal/cache"
+	"time"
+)
+
+// Caches holds the caches used by WithCache, so that their statistics can be published.
+type Caches struct {
+	Users       *cache.Cache[int64, *User]          // User ID -> user.
+	Sessions    *cache.Cache[string, cachedSession] // Authentication token hash -> user.
+	Permissions *cache.Cache[int64, Permissions]    // User ID -> permissions.
+	touched     *cache.Cache[string, struct{}]      // Hashes of tokens touched within the last SessionTouchInterval.
+
+	// generations records when each user's entries were last invalidated, so that a lookup that raced with an invalidation doesn't cache what it read.
+	// tokenGenerations does the same for single authentication tokens, by hash.
+	generations      *cache.Generations[int64]
+	tokenGenerations *cache.Generations[string]
+}
+
+// cachedSession is an authentication token's user, along with when the token expires.
+type cachedSession struct {
+	user   *User
+	expiry time.Time
+}
+
+// Stats returns the statistics of each cache, keyed by name.
+func (c *Caches) Stats() map[string]cache.Stats {
+	return map[string]cache.Stats{
+		"users":       c.Users.Stats(),
+		"sessions":    c.Sessions.Stats(),
+		"permissions": c.Permissions.Stats(),
+	}
+}
+
+// invalidateUser removes everything cached about the user.
+func (c *Caches) invalidateUser(userID int64) {
+	c.generations.Invalidate(userID)
+	c.Users.Delete(userID)
+	c.Permissions.Delete(userID)
+	c.deleteSessions(userID)
+}
+
+// invalidateSessions removes the user's cached authentication tokens.
+func (c *Caches) invalidateSessions(userID int64) {
+	c.generations.Invalidate(userID)
+	c.deleteSessions(userID)
+}
+
+// invalidatePermissions removes the user's cached permissions.
+func (c *Caches) invalidatePermissions(userID int64) {
+	c.generations.Invalidate(userID)
+	c.Permissions.Delete(userID)
+}
+
+// invalidateAllPermissions removes every user's cached permissions.
+func (c *Caches) invalidateAllPermissions() {
+	c.generations.InvalidateAll()
+	c.Permissions.DeleteFunc(func(int64, Permissions) bool { return true })
+}
+
+// invalidateSession removes a single cached authentication token.
+func (c *Caches) invalidateSession(tokenHash string) {
+	c.tokenGenerations.Invalidate(tokenHash)
+	c.Sessions.Delete(tokenHash)
+}
+
+func (c *Caches) deleteSessions(userID int64) {
+	c.Sessions.DeleteFunc(func(_ string, session cachedSession) bool {
+		return session.user.ID == userID
+	})
+}
+
+// unchanged returns a function that reports whether the user's entries have not been invalidated since gen, for use with cache.Cache.SetIf.
+func (c *Caches) unchanged(userID int64, gen uint64) func() bool {
+	return func() bool {
+		return c.generations.Unchanged(userID, gen)
+	}
+}
+
+// WithCache wraps the models so that the lookups made on every authenticated request (users, authentication tokens, and permissions) are cached in-process for ttl.
+// Writes made through the returned models invalidate the affected entries straight away, and lookups that were already running when the entries were invalidated aren't cached.
+// Writes made elsewhere (such as by another server, or by hand) are only seen once the entries expire.
+func WithCache(models Models, ttl time.Duration) (Models, *Caches) {
+	caches := &Caches{
+		Users:            cache.New[int64, *User](ttl),
+		Sessions:         cache.New[string, cachedSession](ttl),
+		Permissions:      cache.New[int64, Permissions](ttl),
+		touched:          cache.New[string, struct{}](SessionTouchInterval),
+		generations:      cache.NewGenerations[int64](ttl),
+		tokenGenerations: cache.NewGenerations[string](ttl),
+	}
+
+	models.Users = cachedUserStore{models.Users, caches}
+	models.Tokens = cachedTokenStore{models.Tokens, caches}
+	models.Permissions = cachedPermissionStore{models.Permissions, caches}
+	models.Roles = cachedRoleStore{models.Roles, caches}
+
+	return models, caches
+}
+
+// copyUser returns a copy of the user, so that callers can't modify cached values.
+func copyUser(user *User) *User {
+	copied := *user
+	return &copied
+}
+
+type cachedUserStore struct {
+	UserStore
+	caches *Caches
+}
+
+func (s cachedUserStore) Get(ctx context.Context, id int64) (*User, error) {
+	if user, ok := s.caches.Users.Get(id); ok {
+		return copyUser(user), nil
+	}
+
+	gen := s.caches.generations.Current()
+
+	user, err := s.UserStore.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.caches.Users.SetIf(id, copyUser(user), s.caches.unchanged(id, gen))
+	return user, nil
+}
+
+// GetForToken only caches authentication tokens, which are looked up on every request. Other tokens are only used once.
+func (s cachedUserStore) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	user, _, err := s.GetForTokenWithExpiry(ctx, tokenScope, tokenPlaintext)
+	return user, err
+}
+
+// GetForTokenWithExpiry checks the token's expiry on every cache hit, so that a cached token isn't accepted after it expires.
+func (s cachedUserStore) GetForTokenWithExpiry(ctx context.Context, tokenScope, tokenPlaintext string) (*User, time.Time, error) {
+	if tokenScope != ScopeAuthentication {
+		return s.UserStore.GetForTokenWithExpiry(ctx, tokenScope, tokenPlaintext)
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	key := string(tokenHash[:])
+
+	if session, ok := s.caches.Sessions.Get(key); ok {
+		if time.Now().Before(session.expiry) {
+			return copyUser(session.user), session.expiry, nil
+		}
+		s.caches.Sessions.Delete(key)
+	}
+
+	// The token's user isn't known until it has been read, so the generation is checked against the user it belongs to afterwards.
+	gen := s.caches.generations.Current()
+	tokenGen := s.caches.tokenGenerations.Current()
+
+	user, expiry, err := s.UserStore.GetForTokenWithExpiry(ctx, tokenScope, tokenPlaintext)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	s.caches.Sessions.SetIf(key, cachedSession{user: copyUser(user), expiry: expiry}, func() bool {
+		return s.caches.generations.Unchanged(user.ID, gen) && s.caches.tokenGenerations.Unchanged(key, tokenGen)
+	})
+	return user, expiry, nil
+}
+
+// Update invalidates the user whether or not it succeeds, since an edit conflict means that the cached copy may be stale.
+func (s cachedUserStore) Update(ctx context.Context, user *User) error {
+	defer s.caches.invalidateUser(user.ID)
+	return s.UserStore.Update(ctx, user)
+}
+
+func (s cachedUserStore) RevokeTokens(ctx context.Context, id int64) error {
+	defer s.caches.invalidateUser(id)
+	return s.UserStore.RevokeTokens(ctx, id)
+}
+
+func (s cachedUserStore) Delete(ctx context.Context, id int64) error {
+	defer s.caches.invalidateUser(id)
+	return s.UserStore.Delete(ctx, id)
+}
+
+type cachedTokenStore struct {
+	TokenStore
+	caches *Caches
+}
+
+// Touch skips tokens that were touched recently, since the database would ignore the update anyway.
+func (s cachedTokenStore) Touch(ctx context.Context, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	key := string(tokenHash[:])
+
+	if _, ok := s.caches.touched.Get(key); ok {
+		return nil
+	}
+
+	err := s.TokenStore.Touch(ctx, tokenPlaintext)
+	if err != nil {
+		return err
+	}
+
+	s.caches.touched.Set(key, struct{}{})
+	return nil
+}
+
+func (s cachedTokenStore) DeleteSession(ctx context.Context, userID, id int64) error {
+	defer s.caches.invalidateSessions(userID)
+	return s.TokenStore.DeleteSession(ctx, userID, id)
+}
+
+func (s cachedTokenStore) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	defer s.caches.invalidateSession(string(tokenHash[:]))
+	return s.TokenStore.Delete(ctx, scope, tokenPlaintext)
+}
+
+func (s cachedTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	defer s.caches.invalidateSessions(userID)
+	return s.TokenStore.DeleteAllForUser(ctx, scope, userID)
+}
+
+type cachedPermissionStore struct {
+	PermissionStore
+	caches *Caches
+}
+
+func (s cachedPermissionStore) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if permissions, ok := s.caches.Permissions.Get(userID); ok {
+		return append(Permissions(nil), permissions...), nil
+	}
+
+	gen := s.caches.generations.Current()
+
+	permissions, err := s.PermissionStore.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.caches.Permissions.SetIf(userID, append(Permissions(nil), permissions...), s.caches.unchanged(userID, gen))
+	return permissions, nil
+}
+
+func (s cachedPermissionStore) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	defer s.caches.invalidatePermissions(userID)
+	return s.PermissionStore.AddForUser(ctx, userID, codes...)
+}
+
+func (s cachedPermissionStore) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	defer s.caches.invalidatePermissions(userID)
+	return s.PermissionStore.RemoveForUser(ctx, userID, code)
+}
+
+type cachedRoleStore struct {
+	RoleStore
+	caches *Caches
+}
+
+func (s cachedRoleStore) AddForUser(ctx context.Context, userID int64, name string) error {
+	defer s.caches.invalidatePermissions(userID)
+	return s.RoleStore.AddForUser(ctx, userID, name)
+}
+
+func (s cachedRoleStore) RemoveForUser(ctx context.Context, userID int64, name string) error {
+	defer s.caches.invalidatePermissions(userID)
+	return s.RoleStore.RemoveForUser(ctx, userID, name)
+}
+
+// Changing a role's permissions changes the permissions of every user with the role, so every user's cached permissions are invalidated.
+func (s cachedRoleStore) AddPermission(ctx context.Context, name, code string) error {
+	defer s.caches.invalidateAllPermissions()
+	return s.RoleStore.AddPermission(ctx, name, code)
+}
+
+func (s cachedRoleStore) RemovePermission(ctx context.Context, name, code string) error {
+	defer s.caches.invalidateAllPermissions()
+	return s.RoleStore.RemovePermission(ctx, name, code)
+}
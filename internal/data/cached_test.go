@@ -0,0 +1,153 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// stubUserStore returns the same user for every token. If loading is set, GetForTokenWithExpiry waits for it to be closed after reading the user, so that tests can interleave other calls with the lookup.
+type stubUserStore struct {
+	UserStore
+	user    *User
+	expiry  time.Time
+	started chan struct{}
+	loading chan struct{}
+}
+
+func (s *stubUserStore) GetForTokenWithExpiry(ctx context.Context, tokenScope, tokenPlaintext string) (*User, time.Time, error) {
+	user := s.user
+	if s.loading != nil {
+		close(s.started)
+		<-s.loading
+	}
+	if user == nil {
+		return nil, time.Time{}, ErrRecordNotFound
+	}
+	return user, s.expiry, nil
+}
+
+type stubTokenStore struct {
+	TokenStore
+}
+
+func (stubTokenStore) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	return nil
+}
+
+func (stubTokenStore) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	return nil
+}
+
+func TestCachedSessionRevokedDuringLookup(t *testing.T) {
+	for name, revoke := range map[string]func(Models) error{
+		"logout": func(m Models) error {
+			return m.Tokens.Delete(context.Background(), ScopeAuthentication, "token")
+		},
+		"logout everywhere": func(m Models) error {
+			return m.Tokens.DeleteAllForUser(context.Background(), ScopeAuthentication, 1)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			users := &stubUserStore{
+				user:    &User{ID: 1},
+				expiry:  time.Now().Add(time.Hour),
+				started: make(chan struct{}),
+				loading: make(chan struct{}),
+			}
+			models, caches := WithCache(Models{Users: users, Tokens: stubTokenStore{}}, time.Minute)
+
+			done := make(chan error)
+			go func() {
+				_, err := models.Users.GetForToken(context.Background(), ScopeAuthentication, "token")
+				done <- err
+			}()
+
+			// The token is revoked after the lookup has read it, but before the lookup has cached it.
+			<-users.started
+			users.user = nil
+			if err := revoke(models); err != nil {
+				t.Fatal(err)
+			}
+			close(users.loading)
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			if entries := caches.Sessions.Stats().Entries; entries != 0 {
+				t.Fatalf("got %d cached sessions; want 0", entries)
+			}
+
+			users.loading = nil
+			_, err := models.Users.GetForToken(context.Background(), ScopeAuthentication, "token")
+			if err != ErrRecordNotFound {
+				t.Fatalf("got %v; want ErrRecordNotFound", err)
+			}
+		})
+	}
+}
+
+func TestCachedSessionExpiry(t *testing.T) {
+	users := &stubUserStore{user: &User{ID: 1}, expiry: time.Now().Add(20 * time.Millisecond)}
+	models, _ := WithCache(Models{Users: users}, time.Minute)
+
+	_, err := models.Users.GetForToken(context.Background(), ScopeAuthentication, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token expires long before the cache entry would.
+	time.Sleep(30 * time.Millisecond)
+	users.user = nil
+
+	_, err = models.Users.GetForToken(context.Background(), ScopeAuthentication, "token")
+	if err != ErrRecordNotFound {
+		t.Fatalf("got %v; want ErrRecordNotFound", err)
+	}
+}
+
+// stubPermissionStore returns the permissions granted by the stub role store's single role.
+type stubPermissionStore struct {
+	PermissionStore
+	roles *stubRoleStore
+}
+
+func (s stubPermissionStore) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	return append(Permissions(nil), s.roles.permissions...), nil
+}
+
+type stubRoleStore struct {
+	RoleStore
+	permissions Permissions
+}
+
+func (s *stubRoleStore) AddPermission(ctx context.Context, name, code string) error {
+	s.permissions = append(s.permissions, code)
+	return nil
+}
+
+func TestCachedPermissionsRoleChange(t *testing.T) {
+	roles := &stubRoleStore{permissions: Permissions{"movies:read"}}
+	models, _ := WithCache(Models{Permissions: stubPermissionStore{roles: roles}, Roles: roles}, time.Minute)
+
+	for _, userID := range []int64{1, 2} {
+		if _, err := models.Permissions.GetAllForUser(context.Background(), userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every user with the role gains the permission, not just the one that was looked up last.
+	if err := models.Roles.AddPermission(context.Background(), "viewer", "movies:write"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, userID := range []int64{1, 2} {
+		permissions, err := models.Permissions.GetAllForUser(context.Background(), userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !permissions.Include("movies:write") {
+			t.Errorf("user %d: got %v; want movies:write to be included", userID, permissions)
+		}
+	}
+}
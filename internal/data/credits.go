@@ -0,0 +1,188 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"time"
+)
+
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// CreditRoles lists every role a person can be credited for, in the order they are listed within a movie's credits.
+var CreditRoles = []string{"director", "writer", "producer", "actor", "composer", "cinematographer", "editor"}
+
+type Credit struct {
+	ID         int64  `json:"id"`
+	MovieID    int64  `json:"movie_id"`
+	PersonID   int64  `json:"person_id"`
+	PersonName string `json:"name"` // Read from the person, for convenience.
+	Role       string `json:"role"`
+	Character  string `json:"character,omitempty"` // Only used for actors.
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID != 0, "person_id", "must be provided")
+	v.Check(credit.PersonID > 0, "person_id", "must be a positive integer")
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of the known roles")
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+}
+
+type CreditModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// creditColumns lists the columns read by Credit.scanDest, in order. The people table must be joined to the query.
+const creditColumns = `credits.id, credits.movie_id, credits.person_id, people.name, credits.role, credits.character`
+
+// scanDest returns the destinations needed to scan creditColumns into the credit.
+func (credit *Credit) scanDest() []any {
+	return []any{
+		&credit.ID,
+		&credit.MovieID,
+		&credit.PersonID,
+		&credit.PersonName,
+		&credit.Role,
+		&credit.Character,
+	}
+}
+
+// Insert credits a person on a movie. If the movie doesn't exist (or has been deleted), or the person doesn't exist, then ErrRecordNotFound is returned.
+// If the person already has the same credit, then ErrDuplicateCredit is returned.
+func (m CreditModel) Insert(ctx context.Context, credit *Credit) error {
+	query := `
+WITH credit AS (
+    INSERT INTO credits (movie_id, person_id, role, character)
+    SELECT id, $2::bigint, $3::text, $4::text
+    FROM movies
+    WHERE id = $1 AND deleted_at IS NULL
+    RETURNING id, person_id
+)
+SELECT credit.id, people.name
+FROM credit
+INNER JOIN people ON people.id = credit.person_id`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.PersonName)
+	if err != nil {
+		switch {
+		// The movie doesn't exist.
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		// The person doesn't exist.
+		case err.Error() == `pq: insert or update on table "credits" violates foreign key constraint "credits_person_id_fkey"`:
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+func (m CreditModel) Get(ctx context.Context, id int64) (*Credit, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT ` + creditColumns + `
+FROM credits
+INNER JOIN people ON people.id = credits.person_id
+WHERE credits.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var credit Credit
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(credit.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &credit, nil
+}
+
+// GetAllForMovie returns every credit of the given movie, grouped by role in the order of CreditRoles. Within each role, credits are listed in the order they were added.
+func (m CreditModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	query := `
+SELECT ` + creditColumns + `
+FROM credits
+INNER JOIN people ON people.id = credits.person_id
+WHERE credits.movie_id = $1
+ORDER BY array_position($2::text[], credits.role), credits.id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, textArray(CreditRoles))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(credit.scanDest()...)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return credits, nil
+}
+
+func (m CreditModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM credits
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
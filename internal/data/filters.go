@@ -1,17 +1,29 @@
 package data
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"github.com/ejacobg/greenlight/internal/validator"
 	"math"
+	"strconv"
 	"strings"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page     int
 	PageSize int
 	Sort     string
 	// SortSafelist defines all the valid values the Sort field can take.
 	SortSafelist []string
+	// If a Cursor is given, then results are paginated by keyset rather than by page number.
+	Cursor string
+	// IncludeTotal controls whether the total number of records is counted when paginating with a Cursor.
+	// Page-based pagination always counts the total.
+	IncludeTotal bool
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -20,11 +32,23 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.Cursor != "" {
+		v.Check(f.Page == 1, "page", "must not be provided alongside a cursor")
+
+		cursor, err := DecodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "must be a valid cursor")
+		} else {
+			// A cursor is only meaningful for the sort order it was created with.
+			v.Check(cursor.Sort == f.Sort, "cursor", "does not match the sort value")
+		}
+	}
 }
 
-// sortColumn will extract the column value the Sort term refers to.
+// SortColumn will extract the column value the Sort term refers to.
 // If the Sort term is invalid, this routine will panic.
-func (f Filters) sortColumn() string {
+func (f Filters) SortColumn() string {
 	for _, safeValue := range f.SortSafelist {
 		if f.Sort == safeValue {
 			return strings.TrimPrefix(f.Sort, "-")
@@ -34,31 +58,99 @@ func (f Filters) sortColumn() string {
 	panic("unsafe sort parameter: " + f.Sort)
 }
 
-// sortDirection will return the appropriate direction keyword depending on if the '-' character is present.
-func (f Filters) sortDirection() string {
+// SortDirection will return the appropriate direction keyword depending on if the '-' character is present.
+func (f Filters) SortDirection() string {
 	if strings.HasPrefix(f.Sort, "-") {
 		return "DESC"
 	}
 	return "ASC"
 }
 
-func (f Filters) limit() int {
+func (f Filters) Limit() int {
 	return f.PageSize
 }
-func (f Filters) offset() int {
+func (f Filters) Offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// queryArgs collects the arguments for a query, handing out a numbered placeholder for each one.
+type queryArgs []any
+
+// add appends a value to the arguments and returns its placeholder (e.g. "$3").
+func (a *queryArgs) add(value any) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// Cursor marks a position within a sorted list of records. Clients only ever see its encoded form.
+type Cursor struct {
+	Sort     string `json:"s"`           // The sort value the cursor was created for.
+	Value    any    `json:"v"`           // Value of the sort column at this position.
+	ID       int64  `json:"i"`           // ID of the record at this position, used to break ties.
+	Backward bool   `json:"b,omitempty"` // True if the cursor fetches the records before this position rather than after it.
+}
+
+// Encode returns the cursor as an opaque, URL-safe string.
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor parses a string created by Cursor.Encode.
+// Numeric values are returned as int64 where possible, and float64 otherwise.
+func DecodeCursor(s string) (Cursor, error) {
+	var cursor Cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	if err = dec.Decode(&cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	switch value := cursor.Value.(type) {
+	case string:
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			cursor.Value = i
+		} else if f, err := value.Float64(); err == nil {
+			cursor.Value = f
+		} else {
+			return cursor, ErrInvalidCursor
+		}
+	default:
+		return cursor, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// cursor decodes the Filters' Cursor field.
+func (f Filters) cursor() (Cursor, error) {
+	cursor, err := DecodeCursor(f.Cursor)
+	if err != nil || cursor.Sort != f.Sort {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
 // Metadata holds extra fields that will be returned for paginated requests.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
-func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+// CalculateMetadata returns the pagination metadata for a given page of results.
+func CalculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
 		// Note that we return an empty Metadata struct if there are no records.
 		return Metadata{}
@@ -0,0 +1,398 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrDuplicateGenre = errors.New("duplicate genre")
+
+type Genre struct {
+	ID         int64    `json:"id"`
+	Slug       string   `json:"slug"` // The canonical form of the genre, which is what movies store.
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases"`     // Other spellings that are normalized to the slug.
+	MovieCount int      `json:"movie_count"` // Number of movies (that haven't been deleted) with this genre.
+}
+
+// Slugify converts a genre into its slug form: lowercase, with each run of characters that aren't letters or digits replaced by a hyphen.
+// For example, "Sci Fi" and "sci_fi" both become "sci-fi". This must match the backfill in the genres migration.
+func Slugify(genre string) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(genre) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	return b.String()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(genre.Slug != "", "slug", "must contain at least one letter or digit")
+	v.Check(genre.Slug == Slugify(genre.Slug), "slug", "must only contain lowercase letters, digits, and single hyphens")
+
+	for _, alias := range genre.Aliases {
+		v.Check(alias != "", "aliases", "must contain at least one letter or digit")
+		v.Check(alias != genre.Slug, "aliases", "must not contain the slug")
+	}
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+}
+
+// GenreLookup maps every genre slug and alias to its genre's canonical slug.
+type GenreLookup map[string]string
+
+// Normalize returns the canonical slug of each genre, in the same order. Genres that don't match any slug or alias are returned separately.
+// Duplicates are kept, so that they can be caught by validation.
+func (l GenreLookup) Normalize(genres []string) (normalized []string, unknown []string) {
+	normalized = make([]string, 0, len(genres))
+
+	for _, genre := range genres {
+		slug, ok := l[Slugify(genre)]
+		if !ok {
+			unknown = append(unknown, genre)
+			continue
+		}
+		normalized = append(normalized, slug)
+	}
+
+	return normalized, unknown
+}
+
+// Canonical returns the canonical slug of each genre, in the same order. Unlike Normalize, genres that aren't known are kept as-is,
+// which makes it suitable for search filters where an unknown genre should simply match nothing.
+func (l GenreLookup) Canonical(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	canonical := make([]string, len(genres))
+	for i, genre := range genres {
+		if slug, ok := l[Slugify(genre)]; ok {
+			canonical[i] = slug
+		} else {
+			canonical[i] = genre
+		}
+	}
+
+	return canonical
+}
+
+// CanonicalGenres replaces the genres in each of the filter's genre criteria with their canonical slugs, so that aliases are matched too.
+func (f *MovieFilters) CanonicalGenres(lookup GenreLookup) {
+	f.Genres = lookup.Canonical(f.Genres)
+	f.AnyGenres = lookup.Canonical(f.AnyGenres)
+	f.ExcludeGenres = lookup.Canonical(f.ExcludeGenres)
+}
+
+type GenreModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// GetLookup returns every known slug and alias.
+func (m GenreModel) GetLookup(ctx context.Context) (GenreLookup, error) {
+	query := `
+SELECT slug, slug
+FROM genres
+UNION ALL
+SELECT genre_aliases.alias, genres.slug
+FROM genre_aliases
+INNER JOIN genres ON genres.id = genre_aliases.genre_id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	lookup := make(GenreLookup)
+
+	for rows.Next() {
+		var name, slug string
+
+		err := rows.Scan(&name, &slug)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		lookup[name] = slug
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return lookup, nil
+}
+
+// genreQuery selects genres along with their aliases and movie counts, and is shared by GetAll and Get.
+const genreQuery = `
+SELECT id, slug, name,
+       ARRAY(SELECT alias FROM genre_aliases WHERE genre_id = genres.id ORDER BY alias),
+       (SELECT count(*) FROM movies WHERE movies.genres @> ARRAY[genres.slug] AND movies.deleted_at IS NULL)
+FROM genres`
+
+// scanDest returns the destinations needed to scan genreQuery into the genre.
+func (genre *Genre) scanDest() []any {
+	return []any{
+		&genre.ID,
+		&genre.Slug,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.MovieCount,
+	}
+}
+
+// GetAll returns every genre, ordered by slug.
+func (m GenreModel) GetAll(ctx context.Context) ([]*Genre, error) {
+	query := genreQuery + `
+ORDER BY slug`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(genre.scanDest()...)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) Get(ctx context.Context, id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := genreQuery + `
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var genre Genre
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(genre.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &genre, nil
+}
+
+// withGenresLock runs fn in a transaction that holds an exclusive lock on the genres table.
+// Slugs and aliases share a namespace that no single constraint can enforce, so changes to the taxonomy are made one at a time.
+// The transaction as a whole is given m.Timeout to complete.
+func (m GenreModel) withGenresLock(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	// This mode still allows the genres to be read.
+	_, err = tx.ExecContext(ctx, `LOCK TABLE genres IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return queryError(ctx, tx.Commit())
+}
+
+// inUse reports whether any of the names are already used as a slug or alias, ignoring those belonging to the genre with the given ID.
+func inUse(ctx context.Context, tx *sql.Tx, names []string, exceptID int64) (bool, error) {
+	query := `
+SELECT EXISTS(
+    SELECT 1 FROM genres WHERE slug = ANY($1) AND id <> $2
+    UNION ALL
+    SELECT 1 FROM genre_aliases WHERE alias = ANY($1) AND genre_id <> $2
+)`
+
+	var exists bool
+	err := tx.QueryRowContext(ctx, query, pq.Array(names), exceptID).Scan(&exists)
+	return exists, queryError(ctx, err)
+}
+
+// Insert adds a new genre along with its aliases. If the slug or any of the aliases are already in use, then ErrDuplicateGenre is returned.
+func (m GenreModel) Insert(ctx context.Context, genre *Genre) error {
+	return m.withGenresLock(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		duplicate, err := inUse(ctx, tx, append([]string{genre.Slug}, genre.Aliases...), 0)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			return ErrDuplicateGenre
+		}
+
+		query := `
+INSERT INTO genres (slug, name)
+VALUES ($1, $2)
+RETURNING id`
+
+		err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name).Scan(&genre.ID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		query = `
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT unnest($1::text[]), $2::bigint`
+
+		_, err = tx.ExecContext(ctx, query, textArray(genre.Aliases), genre.ID)
+		return queryError(ctx, err)
+	})
+}
+
+// replaceGenreQuery gives every movie with the genre $1 the genre $2 instead. Movies that already have $2 just lose $1.
+// Each changed movie gets a new version, attributed to the user $3.
+var replaceGenreQuery = withRevision(`
+UPDATE movies
+SET genres = CASE WHEN genres @> ARRAY[$2::text] THEN array_remove(genres, $1::text) ELSE array_replace(genres, $1::text, $2::text) END,
+    version = version + 1
+WHERE genres @> ARRAY[$1::text]`, "$3", "count(*)")
+
+// Update saves a new name and slug for the genre. If the slug changed, then the old slug becomes an alias, and every movie with the genre is updated.
+// If the new slug is already used by another genre, then ErrDuplicateGenre is returned.
+func (m GenreModel) Update(ctx context.Context, genre *Genre, userID int64) error {
+	return m.withGenresLock(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var oldSlug string
+
+		err := tx.QueryRowContext(ctx, `SELECT slug FROM genres WHERE id = $1`, genre.ID).Scan(&oldSlug)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return queryError(ctx, err)
+			}
+		}
+
+		duplicate, err := inUse(ctx, tx, []string{genre.Slug}, genre.ID)
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			return ErrDuplicateGenre
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE genres SET slug = $1, name = $2 WHERE id = $3`, genre.Slug, genre.Name, genre.ID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		if genre.Slug == oldSlug {
+			return nil
+		}
+
+		// The new slug may have been one of the genre's aliases, in which case it is swapped with the old slug.
+		_, err = tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE alias = $1`, genre.Slug)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)`, oldSlug, genre.ID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		_, err = tx.ExecContext(ctx, replaceGenreQuery, oldSlug, genre.Slug, userID)
+		return queryError(ctx, err)
+	})
+}
+
+// Merge folds the source genre into the target genre. Every movie with the source genre is given the target genre instead,
+// and the source's slug and aliases become aliases of the target. The source genre is then deleted.
+// The number of movies that were changed is returned.
+func (m GenreModel) Merge(ctx context.Context, sourceID, targetID int64, userID int64) (int64, error) {
+	var changed int64
+
+	err := m.withGenresLock(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var source, target string
+
+		query := `
+SELECT (SELECT slug FROM genres WHERE id = $1), (SELECT slug FROM genres WHERE id = $2)`
+
+		var sourceSlug, targetSlug sql.NullString
+		err := tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&sourceSlug, &targetSlug)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+		if !sourceSlug.Valid || !targetSlug.Valid {
+			return ErrRecordNotFound
+		}
+		source, target = sourceSlug.String, targetSlug.String
+
+		err = tx.QueryRowContext(ctx, replaceGenreQuery, source, target, userID).Scan(&changed)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE genre_aliases SET genre_id = $1 WHERE genre_id = $2`, targetID, sourceID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)`, source, targetID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, sourceID)
+		return queryError(ctx, err)
+	})
+
+	return changed, err
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+var ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+
+// IdempotencyRecord holds the response to a request that was sent with an Idempotency-Key header, so that it can be replayed if the request is retried.
+type IdempotencyRecord struct {
+	UserID      int64
+	Key         string
+	RequestHash []byte // Hash of the method, path, and body of the original request.
+	Status      int    // Zero until the original request has finished processing.
+	Header      http.Header
+	Body        []byte
+	Expiry      time.Time
+}
+
+// InProgress reports whether the original request is still being processed.
+func (r *IdempotencyRecord) InProgress() bool {
+	return r.Status == 0
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(key != "", "idempotency_key", "must be provided")
+	v.Check(len(key) <= 255, "idempotency_key", "must not be more than 255 bytes long")
+}
+
+type IdempotencyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// Insert will reserve the record's key for the given user. If the key is already reserved by an unexpired record, ErrDuplicateIdempotencyKey is returned.
+// Every other expired record is removed at the same time, so that the table doesn't grow without limit.
+func (m IdempotencyModel) Insert(ctx context.Context, record *IdempotencyRecord) error {
+	// Expired records are overwritten rather than treated as duplicates.
+	// The record being inserted is left out of the cleanup, since the same statement can't both delete and update a row.
+	query := `
+WITH expired AS (
+    DELETE FROM idempotency_keys
+    WHERE expiry < NOW() AND NOT (user_id = $1 AND key = $2)
+)
+INSERT INTO idempotency_keys (user_id, key, request_hash, expiry)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash, status = NULL, headers = NULL, body = NULL, created_at = NOW(), expiry = EXCLUDED.expiry
+WHERE idempotency_keys.expiry < NOW()`
+
+	args := []interface{}{record.UserID, record.Key, record.RequestHash, record.Expiry}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// If nothing was inserted or updated, then a live record already holds this key.
+	if rowsAffected == 0 {
+		return ErrDuplicateIdempotencyKey
+	}
+
+	return nil
+}
+
+// Get returns the unexpired record stored under the given user and key.
+func (m IdempotencyModel) Get(ctx context.Context, userID int64, key string) (*IdempotencyRecord, error) {
+	query := `
+SELECT user_id, key, request_hash, status, headers, body, expiry
+FROM idempotency_keys
+WHERE user_id = $1 AND key = $2 AND expiry > NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var (
+		record  IdempotencyRecord
+		status  sql.NullInt32
+		headers []byte
+	)
+
+	err := m.DB.QueryRowContext(ctx, query, userID, key).Scan(
+		&record.UserID,
+		&record.Key,
+		&record.RequestHash,
+		&status,
+		&headers,
+		&record.Body,
+		&record.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	// Records that are still in progress won't have a status or headers yet.
+	record.Status = int(status.Int32)
+	if headers != nil {
+		err = json.Unmarshal(headers, &record.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &record, nil
+}
+
+// Complete saves the response for a previously inserted record.
+func (m IdempotencyModel) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	headers, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+UPDATE idempotency_keys
+SET status = $1, headers = $2, body = $3
+WHERE user_id = $4 AND key = $5`
+
+	args := []interface{}{record.Status, headers, record.Body, record.UserID, record.Key}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
+
+// Delete releases a key so that the request can be retried from scratch.
+func (m IdempotencyModel) Delete(ctx context.Context, userID int64, key string) error {
+	query := `
+DELETE FROM idempotency_keys
+WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return queryError(ctx, err)
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// LoginFailure counts the consecutive failed logins for a single email address or IP address.
+// Attempts are counted as failures from the moment they are made, until they are found to have succeeded.
+type LoginFailure struct {
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  time.Time // Logins are refused until this time.
+}
+
+// LoginEmailKey returns the key that failed logins for an email address are counted under.
+// Email addresses are case-insensitive, like the users.email column.
+func LoginEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// LoginIPKey returns the key that failed logins from an IP address are counted under.
+func LoginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginPolicy decides how long logins are refused for after each failure.
+type LoginPolicy struct {
+	FreeAttempts    int           // Failures allowed before logins are delayed.
+	BaseDelay       time.Duration // Delay after the first failure past FreeAttempts. It doubles with each further failure, up to MaxDelay.
+	MaxDelay        time.Duration
+	LockoutAfter    int // Failures after which logins are refused for LockoutDuration instead. Zero disables lockouts.
+	LockoutDuration time.Duration
+	Window          time.Duration // Failures are forgotten once this long has passed since the last one.
+}
+
+// Delay returns how long logins are refused for after the given number of consecutive failures.
+func (p LoginPolicy) Delay(failures int) time.Duration {
+	if p.LockedOut(failures) {
+		return p.LockoutDuration
+	}
+
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// LockedOut reports whether the given number of consecutive failures is enough to lock the key out.
+func (p LoginPolicy) LockedOut(failures int) bool {
+	return p.LockoutAfter > 0 && failures >= p.LockoutAfter
+}
+
+type LoginFailureModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// Attempt reserves a login attempt for the key, counting it as a failure until it is released or deleted.
+// If the key is still waiting out its previous failures, the attempt is refused: ok is false, and nothing is changed.
+// Otherwise, logins are refused for as long as the policy requires after this failure, so concurrent attempts for the same key can't all go ahead.
+// The count starts again if the previous failure is older than the policy's window. Records that have been forgotten in this way are removed at the same time.
+func (m LoginFailureModel) Attempt(ctx context.Context, key string, policy LoginPolicy) (failure *LoginFailure, ok bool, err error) {
+	query := `
+WITH forgotten AS (
+    DELETE FROM login_failures
+    WHERE key <> $1 AND last_failed_at < $3 AND locked_until < $2
+)
+INSERT INTO login_failures (key, failures, last_failed_at, locked_until)
+VALUES ($1, 0, $2, $2)
+ON CONFLICT (key) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, queryError(ctx, err)
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	_, err = tx.ExecContext(ctx, query, key, now, now.Add(-policy.Window))
+	if err != nil {
+		return nil, false, queryError(ctx, err)
+	}
+
+	// The row stays locked until the transaction ends, so concurrent attempts for the same key are reserved one at a time.
+	failure, err = lockLoginFailure(ctx, tx, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if now.Before(failure.LockedUntil) {
+		return failure, false, nil
+	}
+
+	if failure.LastFailedAt.Before(now.Add(-policy.Window)) {
+		failure.Failures = 0
+	}
+	failure.Failures++
+	failure.LastFailedAt = now
+	failure.LockedUntil = now.Add(policy.Delay(failure.Failures))
+
+	err = updateLoginFailure(ctx, tx, failure)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, queryError(ctx, err)
+	}
+
+	return failure, true, nil
+}
+
+// Release undoes an attempt that succeeded, without forgetting the key's earlier failures.
+func (m LoginFailureModel) Release(ctx context.Context, key string, policy LoginPolicy) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	failure, err := lockLoginFailure(ctx, tx, key)
+	if err != nil {
+		switch {
+		// The key may have been deleted by a concurrent request.
+		case errors.Is(err, ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if failure.Failures > 0 {
+		failure.Failures--
+	}
+	if until := failure.LastFailedAt.Add(policy.Delay(failure.Failures)); until.Before(failure.LockedUntil) {
+		failure.LockedUntil = until
+	}
+
+	err = updateLoginFailure(ctx, tx, failure)
+	if err != nil {
+		return err
+	}
+
+	return queryError(ctx, tx.Commit())
+}
+
+// lockLoginFailure reads the key's failures, locking its row until the end of the transaction. ErrRecordNotFound is returned if there are none.
+func lockLoginFailure(ctx context.Context, tx *sql.Tx, key string) (*LoginFailure, error) {
+	query := `
+SELECT key, failures, last_failed_at, locked_until
+FROM login_failures
+WHERE key = $1
+FOR UPDATE`
+
+	var failure LoginFailure
+	err := tx.QueryRowContext(ctx, query, key).Scan(
+		&failure.Key,
+		&failure.Failures,
+		&failure.LastFailedAt,
+		&failure.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &failure, nil
+}
+
+func updateLoginFailure(ctx context.Context, tx *sql.Tx, failure *LoginFailure) error {
+	query := `
+UPDATE login_failures
+SET failures = $2, last_failed_at = $3, locked_until = $4
+WHERE key = $1`
+
+	_, err := tx.ExecContext(ctx, query, failure.Key, failure.Failures, failure.LastFailedAt, failure.LockedUntil)
+	return queryError(ctx, err)
+}
+
+// Delete forgets the failed logins recorded for the key. It is not an error if there are none.
+func (m LoginFailureModel) Delete(ctx context.Context, key string) error {
+	query := `
+DELETE FROM login_failures
+WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return queryError(ctx, err)
+}
@@ -0,0 +1,107 @@
+package memstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"sort"
+)
+
+type APIKeyModel struct {
+	*store
+}
+
+// copyAPIKey returns a copy of the key that shares no memory with the stored one.
+func copyAPIKey(key *data.APIKey) *data.APIKey {
+	copied := *key
+	copied.Hash = append([]byte{}, key.Hash...)
+	copied.Permissions = append(data.Permissions{}, key.Permissions...)
+	return &copied
+}
+
+func (m APIKeyModel) Insert(ctx context.Context, key *data.APIKey) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mimic the foreign key on api_keys.user_id.
+	if _, ok := m.users[key.UserID]; !ok {
+		return fmt.Errorf(`memstore: insert or update on table "api_keys" violates foreign key constraint "api_keys_user_id_fkey"`)
+	}
+
+	for _, existing := range m.apiKeys {
+		if string(existing.Hash) == string(key.Hash) {
+			return fmt.Errorf(`memstore: duplicate key value violates unique constraint "api_keys_hash_key"`)
+		}
+	}
+
+	m.lastAPIKeyID++
+	key.ID = m.lastAPIKeyID
+	key.CreatedAt = now()
+
+	copied := copyAPIKey(key)
+	copied.Plaintext = "" // Only the hash is ever stored.
+	m.apiKeys[key.ID] = copied
+	return nil
+}
+
+func (m APIKeyModel) GetForPlaintext(ctx context.Context, plaintext string) (*data.APIKey, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(plaintext))
+	for _, key := range m.apiKeys {
+		if string(key.Hash) == string(hash[:]) {
+			return copyAPIKey(key), nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+func (m APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*data.APIKey, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := []*data.APIKey{}
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+func (m APIKeyModel) Delete(ctx context.Context, userID, id int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.apiKeys, id)
+	return nil
+}
@@ -0,0 +1,121 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"golang.org/x/exp/slices"
+	"sort"
+)
+
+// CreditModel mimics data.CreditModel.
+type CreditModel struct {
+	*store
+}
+
+// copyCredit returns a copy of the given credit, with the person's name filled in. The caller must hold the store's lock.
+func (s *store) copyCredit(credit *data.Credit) *data.Credit {
+	copied := *credit
+	if person, ok := s.people[credit.PersonID]; ok {
+		copied.PersonName = person.Name
+	}
+	return &copied
+}
+
+// isCredited reports whether the person has any credit on the movie. The caller must hold the store's lock.
+func (s *store) isCredited(movieID, personID int64) bool {
+	for _, credit := range s.credits {
+		if credit.MovieID == movieID && credit.PersonID == personID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m CreditModel) Insert(ctx context.Context, credit *data.Credit) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if movie, ok := m.movies[credit.MovieID]; !ok || movie.DeletedAt != nil {
+		return data.ErrRecordNotFound
+	}
+	if _, ok := m.people[credit.PersonID]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	for _, existing := range m.credits {
+		if existing.MovieID == credit.MovieID && existing.PersonID == credit.PersonID && existing.Role == credit.Role && existing.Character == credit.Character {
+			return data.ErrDuplicateCredit
+		}
+	}
+
+	m.lastCreditID++
+	credit.ID = m.lastCreditID
+	credit.PersonName = m.people[credit.PersonID].Name
+
+	copied := *credit
+	m.credits[credit.ID] = &copied
+	return nil
+}
+
+func (m CreditModel) Get(ctx context.Context, id int64) (*data.Credit, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credit, ok := m.credits[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return m.copyCredit(credit), nil
+}
+
+func (m CreditModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*data.Credit, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credits := []*data.Credit{}
+	for _, credit := range m.credits {
+		if credit.MovieID == movieID {
+			credits = append(credits, m.copyCredit(credit))
+		}
+	}
+
+	// Group by role in the order of data.CreditRoles, then order by ID.
+	sort.Slice(credits, func(i, j int) bool {
+		a, b := credits[i], credits[j]
+		if ra, rb := slices.Index(data.CreditRoles, a.Role), slices.Index(data.CreditRoles, b.Role); ra != rb {
+			return ra < rb
+		}
+		return a.ID < b.ID
+	})
+
+	return credits, nil
+}
+
+func (m CreditModel) Delete(ctx context.Context, id int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.credits[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.credits, id)
+	return nil
+}
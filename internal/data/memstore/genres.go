@@ -0,0 +1,229 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"golang.org/x/exp/slices"
+	"sort"
+)
+
+// seedGenres lists the same genres (slug, name, and aliases) as the genres migration.
+var seedGenres = []data.Genre{
+	{Slug: "action", Name: "Action"},
+	{Slug: "adventure", Name: "Adventure"},
+	{Slug: "animation", Name: "Animation"},
+	{Slug: "comedy", Name: "Comedy"},
+	{Slug: "crime", Name: "Crime"},
+	{Slug: "documentary", Name: "Documentary"},
+	{Slug: "drama", Name: "Drama"},
+	{Slug: "family", Name: "Family"},
+	{Slug: "fantasy", Name: "Fantasy"},
+	{Slug: "horror", Name: "Horror"},
+	{Slug: "musical", Name: "Musical"},
+	{Slug: "mystery", Name: "Mystery"},
+	{Slug: "romance", Name: "Romance"},
+	{Slug: "sci-fi", Name: "Science Fiction", Aliases: []string{"science-fiction"}},
+	{Slug: "thriller", Name: "Thriller"},
+	{Slug: "war", Name: "War"},
+	{Slug: "western", Name: "Western"},
+}
+
+// GenreModel mimics data.GenreModel.
+type GenreModel struct {
+	*store
+}
+
+// copyGenre returns a deep copy of the given genre, with its movie count filled in. The caller must hold the store's lock.
+func (s *store) copyGenre(genre *data.Genre) *data.Genre {
+	copied := *genre
+	copied.Aliases = append([]string{}, genre.Aliases...)
+	sort.Strings(copied.Aliases)
+
+	copied.MovieCount = 0
+	for _, movie := range s.movies {
+		if movie.DeletedAt == nil && slices.Contains(movie.Genres, genre.Slug) {
+			copied.MovieCount++
+		}
+	}
+
+	return &copied
+}
+
+// genreInUse reports whether any of the names are already used as a slug or alias, ignoring those belonging to the genre with the given ID.
+// The caller must hold the store's lock.
+func (s *store) genreInUse(names []string, exceptID int64) bool {
+	for id, genre := range s.genres {
+		if id == exceptID {
+			continue
+		}
+		for _, name := range names {
+			if name == genre.Slug || slices.Contains(genre.Aliases, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replaceGenre gives every movie with the genre old the genre new instead, recording a revision for each one. The caller must hold the store's lock.
+func (s *store) replaceGenre(old, new string, userID int64) int64 {
+	var changed int64
+
+	for _, movie := range s.movies {
+		i := slices.Index(movie.Genres, old)
+		if i < 0 {
+			continue
+		}
+
+		if slices.Contains(movie.Genres, new) {
+			movie.Genres = slices.Delete(movie.Genres, i, i+1)
+		} else {
+			movie.Genres[i] = new
+		}
+		movie.Version++
+		s.recordRevision(movie, userID)
+		changed++
+	}
+
+	return changed
+}
+
+func (m GenreModel) GetLookup(ctx context.Context) (data.GenreLookup, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lookup := make(data.GenreLookup)
+	for _, genre := range m.genres {
+		lookup[genre.Slug] = genre.Slug
+		for _, alias := range genre.Aliases {
+			lookup[alias] = genre.Slug
+		}
+	}
+
+	return lookup, nil
+}
+
+func (m GenreModel) GetAll(ctx context.Context) ([]*data.Genre, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	genres := []*data.Genre{}
+	for _, genre := range m.genres {
+		genres = append(genres, m.copyGenre(genre))
+	}
+
+	sort.Slice(genres, func(i, j int) bool {
+		return genres[i].Slug < genres[j].Slug
+	})
+
+	return genres, nil
+}
+
+func (m GenreModel) Get(ctx context.Context, id int64) (*data.Genre, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	genre, ok := m.genres[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return m.copyGenre(genre), nil
+}
+
+func (m GenreModel) Insert(ctx context.Context, genre *data.Genre) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.genreInUse(append([]string{genre.Slug}, genre.Aliases...), 0) {
+		return data.ErrDuplicateGenre
+	}
+
+	m.lastGenreID++
+	genre.ID = m.lastGenreID
+
+	m.genres[genre.ID] = &data.Genre{
+		ID:      genre.ID,
+		Slug:    genre.Slug,
+		Name:    genre.Name,
+		Aliases: append([]string{}, genre.Aliases...),
+	}
+	return nil
+}
+
+func (m GenreModel) Update(ctx context.Context, genre *data.Genre, userID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.genres[genre.ID]
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+
+	if m.genreInUse([]string{genre.Slug}, genre.ID) {
+		return data.ErrDuplicateGenre
+	}
+
+	oldSlug := stored.Slug
+	stored.Name = genre.Name
+	stored.Slug = genre.Slug
+
+	if genre.Slug == oldSlug {
+		return nil
+	}
+
+	// The new slug may have been one of the genre's aliases, in which case it is swapped with the old slug.
+	if i := slices.Index(stored.Aliases, genre.Slug); i >= 0 {
+		stored.Aliases = slices.Delete(stored.Aliases, i, i+1)
+	}
+	stored.Aliases = append(stored.Aliases, oldSlug)
+
+	m.replaceGenre(oldSlug, genre.Slug, userID)
+	return nil
+}
+
+func (m GenreModel) Merge(ctx context.Context, sourceID, targetID int64, userID int64) (int64, error) {
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	source, ok := m.genres[sourceID]
+	if !ok {
+		return 0, data.ErrRecordNotFound
+	}
+	target, ok := m.genres[targetID]
+	if !ok {
+		return 0, data.ErrRecordNotFound
+	}
+
+	changed := m.replaceGenre(source.Slug, target.Slug, userID)
+
+	target.Aliases = append(target.Aliases, source.Aliases...)
+	target.Aliases = append(target.Aliases, source.Slug)
+	delete(m.genres, sourceID)
+
+	return changed, nil
+}
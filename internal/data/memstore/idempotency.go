@@ -0,0 +1,97 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"time"
+)
+
+type idempotencyKey struct {
+	userID int64
+	key    string
+}
+
+type IdempotencyModel struct {
+	*store
+}
+
+func (m IdempotencyModel) Insert(ctx context.Context, record *data.IdempotencyRecord) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{record.UserID, record.Key}
+
+	// Expired records are overwritten rather than treated as duplicates.
+	if existing, ok := m.idempotency[k]; ok && existing.Expiry.After(time.Now()) {
+		return data.ErrDuplicateIdempotencyKey
+	}
+
+	// Every other expired record is removed, as in the database.
+	for other, existing := range m.idempotency {
+		if existing.Expiry.Before(time.Now()) {
+			delete(m.idempotency, other)
+		}
+	}
+
+	m.idempotency[k] = &data.IdempotencyRecord{
+		UserID:      record.UserID,
+		Key:         record.Key,
+		RequestHash: record.RequestHash,
+		Expiry:      record.Expiry,
+	}
+	return nil
+}
+
+func (m IdempotencyModel) Get(ctx context.Context, userID int64, key string) (*data.IdempotencyRecord, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.idempotency[idempotencyKey{userID, key}]
+	if !ok || !record.Expiry.After(time.Now()) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	copied := *record
+	copied.Header = record.Header.Clone()
+	return &copied, nil
+}
+
+func (m IdempotencyModel) Complete(ctx context.Context, record *data.IdempotencyRecord) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.idempotency[idempotencyKey{record.UserID, record.Key}]
+	if !ok {
+		// An UPDATE that matches no rows is not an error.
+		return nil
+	}
+
+	existing.Status = record.Status
+	existing.Header = record.Header.Clone()
+	existing.Body = append([]byte(nil), record.Body...)
+	return nil
+}
+
+func (m IdempotencyModel) Delete(ctx context.Context, userID int64, key string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotency, idempotencyKey{userID, key})
+	return nil
+}
@@ -0,0 +1,84 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"time"
+)
+
+type LoginFailureModel struct {
+	*store
+}
+
+func (m LoginFailureModel) Attempt(ctx context.Context, key string, policy data.LoginPolicy) (*data.LoginFailure, bool, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := time.Now()
+	forgotten := t.Add(-policy.Window)
+
+	for k, f := range m.loginFailures {
+		if k != key && f.LastFailedAt.Before(forgotten) && f.LockedUntil.Before(t) {
+			delete(m.loginFailures, k)
+		}
+	}
+
+	failure, ok := m.loginFailures[key]
+	if !ok {
+		failure = &data.LoginFailure{Key: key, LastFailedAt: t, LockedUntil: t}
+		m.loginFailures[key] = failure
+	}
+
+	if t.Before(failure.LockedUntil) {
+		copied := *failure
+		return &copied, false, nil
+	}
+
+	if failure.LastFailedAt.Before(forgotten) {
+		failure.Failures = 0
+	}
+	failure.Failures++
+	failure.LastFailedAt = t
+	failure.LockedUntil = t.Add(policy.Delay(failure.Failures))
+
+	copied := *failure
+	return &copied, true, nil
+}
+
+func (m LoginFailureModel) Release(ctx context.Context, key string, policy data.LoginPolicy) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failure, ok := m.loginFailures[key]
+	if !ok {
+		return nil
+	}
+
+	if failure.Failures > 0 {
+		failure.Failures--
+	}
+	if until := failure.LastFailedAt.Add(policy.Delay(failure.Failures)); until.Before(failure.LockedUntil) {
+		failure.LockedUntil = until
+	}
+	return nil
+}
+
+func (m LoginFailureModel) Delete(ctx context.Context, key string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginFailures, key)
+	return nil
+}
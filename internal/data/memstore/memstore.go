@@ -0,0 +1,129 @@
+// Package memstore provides an in-memory implementation of the data.Models stores.
+// It is intended for tests that need to exercise handlers without a PostgreSQL database, and mimics the errors returned by the real models.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"sync"
+	"time"
+)
+
+// store holds all of the in-memory tables. The models share a single store (and lock) so that queries spanning multiple tables see a consistent view.
+type store struct {
+	mu sync.Mutex
+
+	movies      map[int64]*data.Movie
+	lastMovieID int64
+
+	revisions map[int64][]*data.MovieRevision // Movie ID -> revisions, oldest first.
+
+	genres      map[int64]*data.Genre // Movie counts aren't stored, and are calculated on read.
+	lastGenreID int64
+
+	people       map[int64]*data.Person
+	lastPersonID int64
+
+	credits      map[int64]*data.Credit // The person's name isn't stored, and is read from the person.
+	lastCreditID int64
+
+	reviews      map[int64]*data.Review
+	lastReviewID int64
+
+	movieLists map[string]map[movieListKey]time.Time // Table name -> movies on each user's list, and when they were added.
+
+	users      map[int64]*data.User
+	lastUserID int64
+
+	tokens      map[string]*data.Token // Keyed by the token hash.
+	lastTokenID int64
+
+	apiKeys      map[int64]*data.APIKey
+	lastAPIKeyID int64
+
+	permissions      []string                  // Every known permission code.
+	usersPermissions map[int64]map[string]bool // User ID -> granted permission codes.
+
+	roles      []*data.Role             // Every role, ordered by ID.
+	usersRoles map[int64]map[int64]bool // User ID -> granted role IDs.
+
+	idempotency map[idempotencyKey]*data.IdempotencyRecord
+
+	revocations map[string]time.Time // JWT ID -> expiry.
+
+	loginFailures map[string]*data.LoginFailure
+}
+
+// NewModels returns a data.Models value backed by a new, empty in-memory store.
+// The store is seeded with the same permission codes, roles, and genres as the database migrations.
+func NewModels() data.Models {
+	s := &store{
+		movies:    make(map[int64]*data.Movie),
+		revisions: make(map[int64][]*data.MovieRevision),
+		genres:    make(map[int64]*data.Genre),
+		people:    make(map[int64]*data.Person),
+		credits:   make(map[int64]*data.Credit),
+		reviews:   make(map[int64]*data.Review),
+		movieLists: map[string]map[movieListKey]time.Time{
+			"watchlist": make(map[movieListKey]time.Time),
+			"watched":   make(map[movieListKey]time.Time),
+		},
+		users:            make(map[int64]*data.User),
+		tokens:           make(map[string]*data.Token),
+		apiKeys:          make(map[int64]*data.APIKey),
+		permissions:      []string{"movies:read", "movies:write", "movies:admin", "users:admin"},
+		usersPermissions: make(map[int64]map[string]bool),
+		usersRoles:       make(map[int64]map[int64]bool),
+		idempotency:      make(map[idempotencyKey]*data.IdempotencyRecord),
+		revocations:      make(map[string]time.Time),
+		loginFailures:    make(map[string]*data.LoginFailure),
+	}
+
+	for i := range seedGenres {
+		genre := seedGenres[i]
+		s.lastGenreID++
+		genre.ID = s.lastGenreID
+		genre.Aliases = append([]string{}, genre.Aliases...)
+		s.genres[genre.ID] = &genre
+	}
+
+	for i := range seedRoles {
+		role := seedRoles[i]
+		role.ID = int64(i + 1)
+		role.Permissions = append(data.Permissions{}, role.Permissions...)
+		s.roles = append(s.roles, &role)
+	}
+
+	return data.Models{
+		APIKeys:     APIKeyModel{s},
+		Credits:     CreditModel{s},
+		Genres:      GenreModel{s},
+		Idempotency: IdempotencyModel{s},
+		Logins:      LoginFailureModel{s},
+		Movies:      MovieModel{s},
+		Revisions:   MovieRevisionModel{s},
+		People:      PersonModel{s},
+		Permissions: PermissionModel{s},
+		Reviews:     ReviewModel{s},
+		Revocations: RevocationModel{s},
+		Roles:       RoleModel{s},
+		Tokens:      TokenModel{s},
+		Users:       UserModel{s},
+		Watchlist:   MovieListModel{s, "watchlist"},
+		Watched:     MovieListModel{s, "watched"},
+	}
+}
+
+// now returns the current time, truncated to match the timestamp(0) columns used in the database.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// contextError mimics the data models by returning data.ErrQueryTimeout if the context has already been cancelled.
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", data.ErrQueryTimeout, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"sort"
+	"time"
+)
+
+// movieListKey identifies a movie on a user's list.
+type movieListKey struct {
+	userID  int64
+	movieID int64
+}
+
+// MovieListModel mimics data.MovieListModel. Each list is a separate table within the store.
+type MovieListModel struct {
+	*store
+	table string
+}
+
+func (m MovieListModel) Add(ctx context.Context, userID, movieID int64) (time.Time, error) {
+	if err := contextError(ctx); err != nil {
+		return time.Time{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if movie, ok := m.movies[movieID]; !ok || movie.DeletedAt != nil {
+		return time.Time{}, data.ErrRecordNotFound
+	}
+
+	addedAt := now()
+	m.movieLists[m.table][movieListKey{userID, movieID}] = addedAt
+	return addedAt, nil
+}
+
+func (m MovieListModel) Remove(ctx context.Context, userID, movieID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := movieListKey{userID, movieID}
+	if _, ok := m.movieLists[m.table][key]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.movieLists[m.table], key)
+	return nil
+}
+
+func (m MovieListModel) GetAll(ctx context.Context, userID int64, filters data.Filters) ([]*data.MovieListEntry, data.Metadata, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []*data.MovieListEntry{}
+	for key, addedAt := range m.movieLists[m.table] {
+		movie, ok := m.movies[key.movieID]
+		if key.userID != userID || !ok || movie.DeletedAt != nil {
+			continue
+		}
+		entries = append(entries, &data.MovieListEntry{Movie: copyMovie(movie), AddedAt: addedAt})
+	}
+
+	// Order by the sort column, using the movie ID as a tie-breaker.
+	column, descending := filters.SortColumn(), filters.SortDirection() == "DESC"
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+
+		var cmp int
+		if column == "added_at" {
+			cmp = compareValues(a.AddedAt.Unix(), b.AddedAt.Unix())
+		} else {
+			cmp = compareValues(movieSortValue(a.Movie, column), movieSortValue(b.Movie, column))
+		}
+		if descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return a.Movie.ID < b.Movie.ID
+	})
+
+	totalRecords := len(entries)
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	start := filters.Offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.Limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return entries[start:end], metadata, nil
+}
@@ -0,0 +1,606 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"golang.org/x/exp/slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type MovieModel struct {
+	*store
+}
+
+// copyMovie returns a deep copy of the given movie, so that callers can't modify the stored value.
+func copyMovie(movie *data.Movie) *data.Movie {
+	copied := *movie
+	copied.Genres = append([]string(nil), movie.Genres...)
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		copied.DeletedAt = &deletedAt
+	}
+	if movie.PosterUpdatedAt != nil {
+		posterUpdatedAt := *movie.PosterUpdatedAt
+		copied.PosterUpdatedAt = &posterUpdatedAt
+	}
+	return &copied
+}
+
+func (m MovieModel) Insert(ctx context.Context, movie *data.Movie, userID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastMovieID++
+	movie.ID = m.lastMovieID
+	movie.CreatedAt = now()
+	movie.Version = 1
+
+	m.movies[movie.ID] = copyMovie(movie)
+	m.recordRevision(movie, userID)
+	return nil
+}
+
+func (m MovieModel) InsertMany(ctx context.Context, movies []*data.Movie, userID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, movie := range movies {
+		m.lastMovieID++
+		movie.ID = m.lastMovieID
+		movie.CreatedAt = now()
+		movie.Version = 1
+
+		m.movies[movie.ID] = copyMovie(movie)
+		m.recordRevision(movie, userID)
+	}
+	return nil
+}
+
+func (m MovieModel) Get(ctx context.Context, id int64) (*data.Movie, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt != nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+func (m MovieModel) GetAll(ctx context.Context, filters data.MovieFilters) ([]*data.Movie, data.Metadata, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Instantiate an empty (rather than nil) slice, just like data.MovieModel.
+	movies := []*data.Movie{}
+	for _, movie := range m.movies {
+		if m.matchesFilters(movie, filters) {
+			copied := copyMovie(movie)
+			copied.Highlight = highlight(copied.Title, filters.Title)
+			movies = append(movies, copied)
+		}
+	}
+
+	sortMovies(movies, filters)
+
+	if filters.Cursor != "" {
+		return getAllByCursor(movies, filters.Filters)
+	}
+
+	totalRecords := len(movies)
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	start := filters.Offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.Limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+	movies = movies[start:end]
+
+	if metadata.CurrentPage < metadata.LastPage && len(movies) > 0 && filters.Sort != "relevance" {
+		metadata.NextCursor = movieCursor(movies[len(movies)-1], filters.Filters, false)
+	}
+
+	return movies, metadata, nil
+}
+
+func (m MovieModel) Export(ctx context.Context, filters data.MovieFilters, fn func(*data.Movie) error) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	// Copy the matching movies first, so that the lock isn't held while fn runs.
+	m.mu.Lock()
+	var movies []*data.Movie
+	for _, movie := range m.movies {
+		if m.matchesFilters(movie, filters) {
+			movies = append(movies, copyMovie(movie))
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(movies, func(i, j int) bool {
+		return movies[i].ID < movies[j].ID
+	})
+
+	for _, movie := range movies {
+		if err := contextError(ctx); err != nil {
+			return err
+		}
+
+		if err := fn(movie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesFilters mimics the conditions in data.MovieFilters. The caller must hold the store's lock.
+func (s *store) matchesFilters(movie *data.Movie, f data.MovieFilters) bool {
+	switch {
+	case movie.DeletedAt != nil && !f.IncludeDeleted:
+		return false
+	case !matchesTitle(movie.Title, f.Title) && !(f.Fuzzy && similarity(movie.Title, f.Title) >= 0.3):
+		return false
+	case !containsAll(movie.Genres, f.Genres):
+		return false
+	case len(f.AnyGenres) > 0 && !containsAny(movie.Genres, f.AnyGenres):
+		return false
+	case containsAny(movie.Genres, f.ExcludeGenres):
+		return false
+	case f.YearMin != 0 && int(movie.Year) < f.YearMin:
+		return false
+	case f.YearMax != 0 && int(movie.Year) > f.YearMax:
+		return false
+	case f.RuntimeMin != 0 && int(movie.Runtime) < f.RuntimeMin:
+		return false
+	case f.RuntimeMax != 0 && int(movie.Runtime) > f.RuntimeMax:
+		return false
+	case f.PersonID != 0 && !s.isCredited(movie.ID, f.PersonID):
+		return false
+	default:
+		return true
+	}
+}
+
+// getAllByCursor returns the page of sorted movies immediately after (or before) the filter's cursor.
+func getAllByCursor(movies []*data.Movie, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	cursor, err := data.DecodeCursor(filters.Cursor)
+	if err != nil || cursor.Sort != filters.Sort {
+		return nil, data.Metadata{}, data.ErrInvalidCursor
+	}
+
+	column := filters.SortColumn()
+	if column == "relevance" {
+		return nil, data.Metadata{}, data.ErrInvalidCursor
+	}
+	if !data.ValidMovieCursor(cursor, column) {
+		return nil, data.Metadata{}, data.ErrInvalidCursor
+	}
+
+	totalRecords := len(movies)
+
+	// Find the first movie that sorts at or after the cursor's position, and the first that sorts after it.
+	// The movie at the cursor (if it still exists) lies between the two, and is left out of pages in both directions.
+	descending := filters.SortDirection() == "DESC"
+	search := func(inclusive bool) int {
+		return sort.Search(len(movies), func(i int) bool {
+			cmp := compareValues(movieSortValue(movies[i], column), cursor.Value)
+			if descending {
+				cmp = -cmp
+			}
+			// Ties are broken by ID, which is always ascending.
+			if cmp == 0 {
+				cmp = compareValues(movies[i].ID, cursor.ID)
+			}
+			return cmp > 0 || (inclusive && cmp == 0)
+		})
+	}
+	before, after := search(true), search(false)
+
+	var more bool
+	if cursor.Backward {
+		start := before - filters.Limit()
+		if start < 0 {
+			start = 0
+		}
+		more = start > 0
+		movies = movies[start:before]
+	} else {
+		end := after + filters.Limit()
+		if end > len(movies) {
+			end = len(movies)
+		}
+		more = end < len(movies)
+		movies = movies[after:end]
+	}
+
+	metadata := data.Metadata{PageSize: filters.PageSize}
+
+	if len(movies) > 0 {
+		if more || cursor.Backward {
+			metadata.NextCursor = movieCursor(movies[len(movies)-1], filters, false)
+		}
+		if more || !cursor.Backward {
+			metadata.PrevCursor = movieCursor(movies[0], filters, true)
+		}
+	}
+
+	if filters.IncludeTotal {
+		metadata.TotalRecords = totalRecords
+	}
+
+	return movies, metadata, nil
+}
+
+// movieCursor returns an encoded cursor positioned at the given movie.
+func movieCursor(movie *data.Movie, filters data.Filters, backward bool) string {
+	return data.Cursor{
+		Sort:     filters.Sort,
+		Value:    movieSortValue(movie, filters.SortColumn()),
+		ID:       movie.ID,
+		Backward: backward,
+	}.Encode()
+}
+
+func (m MovieModel) Update(ctx context.Context, movie *data.Movie, userID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.movies[movie.ID]
+	if !ok || existing.Version != movie.Version || existing.DeletedAt != nil {
+		return data.ErrEditConflict
+	}
+
+	movie.Version++
+	m.movies[movie.ID] = copyMovie(movie)
+	m.recordRevision(movie, userID)
+	return nil
+}
+
+func (m MovieModel) Delete(ctx context.Context, id int64, version int32, userID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Deleted movies are treated as though they don't exist, just like the other methods.
+	movie, ok := m.movies[id]
+	ok = ok && movie.DeletedAt == nil
+
+	if version != 0 && (!ok || movie.Version != version) {
+		return data.ErrEditConflict
+	}
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+
+	deletedAt := now()
+	movie.DeletedAt = &deletedAt
+	movie.Version++
+	m.recordRevision(movie, userID)
+	return nil
+}
+
+func (m MovieModel) Restore(ctx context.Context, id int64, userID int64) (*data.Movie, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	movie, ok := m.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return nil, data.ErrRecordNotFound
+	}
+
+	movie.DeletedAt = nil
+	movie.Version++
+	m.recordRevision(movie, userID)
+	return copyMovie(movie), nil
+}
+
+func (m MovieModel) UpdatePoster(ctx context.Context, movie *data.Movie) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.movies[movie.ID]
+	if !ok || stored.PosterVersion != movie.PosterVersion || stored.DeletedAt != nil {
+		return data.ErrEditConflict
+	}
+
+	// The stored movie and the caller's movie are given separate copies of the time, so that neither can modify the other.
+	movie.PosterUpdatedAt, stored.PosterUpdatedAt = nil, nil
+	if movie.PosterType != "" {
+		updatedAt := now()
+		storedUpdatedAt := updatedAt
+		movie.PosterUpdatedAt, stored.PosterUpdatedAt = &updatedAt, &storedUpdatedAt
+	}
+
+	stored.PosterVersion++
+	movie.PosterVersion = stored.PosterVersion
+	stored.PosterType = movie.PosterType
+	return nil
+}
+
+func (m MovieModel) Purge(ctx context.Context, before time.Time) ([]int64, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged []int64
+	for id, movie := range m.movies {
+		if movie.DeletedAt != nil && movie.DeletedAt.Before(before) {
+			// Mimic the ON DELETE CASCADE of the tables that reference movies.
+			delete(m.movies, id)
+			delete(m.revisions, id)
+			for reviewID, review := range m.reviews {
+				if review.MovieID == id {
+					delete(m.reviews, reviewID)
+				}
+			}
+			for creditID, credit := range m.credits {
+				if credit.MovieID == id {
+					delete(m.credits, creditID)
+				}
+			}
+			for _, list := range m.movieLists {
+				for key := range list {
+					if key.movieID == id {
+						delete(list, key)
+					}
+				}
+			}
+			purged = append(purged, id)
+		}
+	}
+	return purged, nil
+}
+
+// lexemes splits text into lowercase words, approximating to_tsvector('simple', text).
+func lexemes(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesTitle approximates matching a title against data.MovieFilters' prefix query: every word in the search must be the start of a word in the title.
+// A search without any words matches every title.
+func matchesTitle(title, search string) bool {
+	titleWords := lexemes(title)
+	for _, word := range lexemes(search) {
+		if !hasPrefix(titleWords, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPrefix reports whether any of the words start with the given prefix.
+func hasPrefix(words []string, prefix string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// trigrams returns the set of trigrams in the text, in the same way as pg_trgm: each word is lowercased and padded with two spaces in front and one behind.
+func trigrams(text string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range lexemes(text) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// similarity approximates pg_trgm's similarity() function.
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+
+	total := len(ta) + len(tb) - shared
+	if total == 0 {
+		return 0
+	}
+	return float64(shared) / float64(total)
+}
+
+// rank approximates the relevance score used by data.MovieFilters: trigram similarity for fuzzy searches, or the fraction of title words matched otherwise.
+func rank(title string, f data.MovieFilters) float64 {
+	if f.Fuzzy {
+		return similarity(title, f.Title)
+	}
+
+	titleWords := lexemes(title)
+	if len(titleWords) == 0 {
+		return 0
+	}
+
+	matched := 0
+	for _, word := range titleWords {
+		if matchesAnyPrefix(word, lexemes(f.Title)) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(titleWords))
+}
+
+// matchesAnyPrefix reports whether the word starts with any of the given prefixes.
+func matchesAnyPrefix(word string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlight wraps each word of the title that matches the search in <mark> tags, approximating ts_headline().
+// It returns the empty string if the search doesn't contain any words.
+func highlight(title, search string) string {
+	prefixes := lexemes(search)
+	if len(prefixes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 && matchesAnyPrefix(strings.ToLower(string(word)), prefixes) {
+			b.WriteString("<mark>" + string(word) + "</mark>")
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+
+	for _, r := range title {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+
+	return b.String()
+}
+
+// containsAll mimics the @> array operator.
+func containsAll(values, required []string) bool {
+	for _, r := range required {
+		if !slices.Contains(values, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// movieSortValue returns the value of the given sort column for a movie.
+func movieSortValue(movie *data.Movie, column string) any {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return int64(movie.Year)
+	case "runtime":
+		return int64(movie.Runtime)
+	case "rating":
+		return movie.AverageRating
+	default:
+		return movie.ID
+	}
+}
+
+// containsAny mimics the && array operator.
+func containsAny(values, candidates []string) bool {
+	for _, c := range candidates {
+		if slices.Contains(values, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortMovies orders the movies by the filter's sort column, using the ID as a tie-breaker.
+// Sorting by relevance always puts the best matches first.
+func sortMovies(movies []*data.Movie, filters data.MovieFilters) {
+	column := filters.SortColumn()
+	descending := filters.SortDirection() == "DESC" || column == "relevance"
+
+	sort.SliceStable(movies, func(i, j int) bool {
+		a, b := movies[i], movies[j]
+
+		var cmp int
+		if column == "relevance" {
+			cmp = compareValues(rank(a.Title, filters), rank(b.Title, filters))
+		} else {
+			cmp = compareValues(movieSortValue(a, column), movieSortValue(b, column))
+		}
+		if descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return a.ID < b.ID
+	})
+}
+
+// compareValues compares two sort values, returning -1, 0, or 1. Strings are compared with strings, and all other values are compared as numbers.
+func compareValues(a, b any) int {
+	if as, ok := a.(string); ok {
+		bs, _ := b.(string)
+		return strings.Compare(as, bs)
+	}
+
+	af, bf := toFloat(a), toFloat(b)
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toFloat(v any) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
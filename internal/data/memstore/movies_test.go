@@ -0,0 +1,121 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ejacobg/greenlight/internal/data"
+)
+
+// insertMovies inserts n movies titled "Movie 1" to "Movie n", with the years 2001 to 2000+n.
+func insertMovies(t *testing.T, models data.Models, n int) {
+	t.Helper()
+
+	for i := 1; i <= n; i++ {
+		movie := &data.Movie{Title: fmt.Sprintf("Movie %d", i), Year: int32(2000 + i), Runtime: 100, Genres: []string{"drama"}}
+		if err := models.Movies.Insert(context.Background(), movie, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMovieErrors(t *testing.T) {
+	ctx := context.Background()
+	models := NewModels()
+	insertMovies(t, models, 1)
+
+	if _, err := models.Movies.Get(ctx, 2); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("Get of a missing movie: got %v; want ErrRecordNotFound", err)
+	}
+
+	movie, err := models.Movies.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both copies start at the same version, so only the first update can succeed.
+	stale := *movie
+	if err := models.Movies.Update(ctx, movie, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Movies.Update(ctx, &stale, 1); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("Update of a stale movie: got %v; want ErrEditConflict", err)
+	}
+	if err := models.Movies.Delete(ctx, 1, stale.Version, 1); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("Delete of a stale version: got %v; want ErrEditConflict", err)
+	}
+
+	// Posters are versioned separately, so a poster change only conflicts with another poster change.
+	stalePoster := *movie
+	movie.PosterType = "image/png"
+	if err := models.Movies.UpdatePoster(ctx, movie); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Movies.UpdatePoster(ctx, &stalePoster); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("UpdatePoster of a stale poster version: got %v; want ErrEditConflict", err)
+	}
+
+	if err := models.Movies.Delete(ctx, 1, movie.Version, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.Movies.Get(ctx, 1); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("Get of a deleted movie: got %v; want ErrRecordNotFound", err)
+	}
+	if err := models.Movies.Delete(ctx, 1, 0, 1); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("Delete of a deleted movie: got %v; want ErrRecordNotFound", err)
+	}
+}
+
+func TestMovieCursorPages(t *testing.T) {
+	ctx := context.Background()
+	models := NewModels()
+	insertMovies(t, models, 6)
+
+	filters := data.MovieFilters{Filters: data.Filters{Page: 1, PageSize: 2, Sort: "-year", SortSafelist: []string{"-year"}}}
+
+	// page returns the IDs of the movies on the page, and its metadata.
+	page := func(cursor string) ([]int64, data.Metadata) {
+		t.Helper()
+
+		filters.Cursor = cursor
+		movies, metadata, err := models.Movies.GetAll(ctx, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int64{}
+		for _, movie := range movies {
+			ids = append(ids, movie.ID)
+		}
+		return ids, metadata
+	}
+
+	// The newest movies come first, so each page holds the next two lowest IDs.
+	want := [][]int64{{6, 5}, {4, 3}, {2, 1}}
+
+	ids, metadata := page("")
+	if fmt.Sprint(ids) != fmt.Sprint(want[0]) {
+		t.Fatalf("first page: got %v; want %v", ids, want[0])
+	}
+
+	for i := 1; i < len(want); i++ {
+		ids, metadata = page(metadata.NextCursor)
+		if fmt.Sprint(ids) != fmt.Sprint(want[i]) {
+			t.Fatalf("page %d forwards: got %v; want %v", i+1, ids, want[i])
+		}
+	}
+
+	// The movie at the cursor was on the page that the cursor came from, so it mustn't be repeated on the page before it.
+	for i := len(want) - 2; i >= 0; i-- {
+		ids, metadata = page(metadata.PrevCursor)
+		if fmt.Sprint(ids) != fmt.Sprint(want[i]) {
+			t.Fatalf("page %d backwards: got %v; want %v", i+1, ids, want[i])
+		}
+	}
+
+	if metadata.PrevCursor != "" {
+		t.Error("got a previous cursor on the first page")
+	}
+}
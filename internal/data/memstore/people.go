@@ -0,0 +1,139 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"sort"
+)
+
+// PersonModel mimics data.PersonModel.
+type PersonModel struct {
+	*store
+}
+
+func (m PersonModel) Insert(ctx context.Context, person *data.Person) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastPersonID++
+	person.ID = m.lastPersonID
+	person.CreatedAt = now()
+	person.Version = 1
+
+	copied := *person
+	m.people[person.ID] = &copied
+	return nil
+}
+
+func (m PersonModel) Get(ctx context.Context, id int64) (*data.Person, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	person, ok := m.people[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	copied := *person
+	return &copied, nil
+}
+
+func (m PersonModel) GetAll(ctx context.Context, name string, filters data.Filters) ([]*data.Person, data.Metadata, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	people := []*data.Person{}
+	for _, person := range m.people {
+		if matchesTitle(person.Name, name) {
+			copied := *person
+			people = append(people, &copied)
+		}
+	}
+
+	// Order by the sort column, using the ID as a tie-breaker.
+	column, descending := filters.SortColumn(), filters.SortDirection() == "DESC"
+	sort.Slice(people, func(i, j int) bool {
+		a, b := people[i], people[j]
+
+		var cmp int
+		if column == "name" {
+			cmp = compareValues(a.Name, b.Name)
+		} else {
+			cmp = compareValues(a.ID, b.ID)
+		}
+		if descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return a.ID < b.ID
+	})
+
+	totalRecords := len(people)
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	start := filters.Offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.Limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return people[start:end], metadata, nil
+}
+
+func (m PersonModel) Update(ctx context.Context, person *data.Person) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.people[person.ID]
+	if !ok || existing.Version != person.Version {
+		return data.ErrEditConflict
+	}
+
+	person.Version++
+	copied := *person
+	m.people[person.ID] = &copied
+	return nil
+}
+
+func (m PersonModel) Delete(ctx context.Context, id int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.people[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	// Mimic the ON DELETE CASCADE of the credits table.
+	delete(m.people, id)
+	for creditID, credit := range m.credits {
+		if credit.PersonID == id {
+			delete(m.credits, creditID)
+		}
+	}
+	return nil
+}
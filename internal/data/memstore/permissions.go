@@ -0,0 +1,123 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"golang.org/x/exp/slices"
+)
+
+type PermissionModel struct {
+	*store
+}
+
+func (m PermissionModel) GetAll(ctx context.Context) (data.Permissions, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	permissions := append(data.Permissions{}, m.permissions...)
+	slices.Sort(permissions)
+	return permissions, nil
+}
+
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var permissions data.Permissions
+	if _, ok := m.users[userID]; !ok {
+		return permissions, nil
+	}
+
+	// Permissions may be granted directly, or through any of the user's roles.
+	granted := make(map[string]bool)
+	for code := range m.usersPermissions[userID] {
+		granted[code] = true
+	}
+	for _, role := range m.roles {
+		if m.usersRoles[userID][role.ID] {
+			for _, code := range role.Permissions {
+				granted[code] = true
+			}
+		}
+	}
+
+	for _, code := range m.permissions {
+		if granted[code] {
+			permissions = append(permissions, code)
+		}
+	}
+	slices.Sort(permissions)
+
+	return permissions, nil
+}
+
+func (m PermissionModel) GetAllDirectForUser(ctx context.Context, userID int64) (data.Permissions, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var permissions data.Permissions
+	for code := range m.usersPermissions[userID] {
+		permissions = append(permissions, code)
+	}
+	slices.Sort(permissions)
+
+	return permissions, nil
+}
+
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mimic the foreign key on users_permissions.user_id.
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf(`memstore: insert or update on table "users_permissions" violates foreign key constraint "users_permissions_user_id_fkey"`)
+	}
+
+	granted := m.usersPermissions[userID]
+	if granted == nil {
+		granted = make(map[string]bool)
+	}
+
+	// Unknown codes are silently skipped, just like the INSERT ... SELECT in data.PermissionModel.
+	for _, code := range codes {
+		if slices.Contains(m.permissions, code) {
+			granted[code] = true
+		}
+	}
+
+	m.usersPermissions[userID] = granted
+	return nil
+}
+
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.usersPermissions[userID][code] {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.usersPermissions[userID], code)
+	return nil
+}
@@ -0,0 +1,175 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+	"sort"
+)
+
+type ReviewModel struct {
+	*store
+}
+
+// updateRating mimics data.ReviewModel by recalculating the rating of the given movie from its reviews. The caller must hold the store's lock.
+func (s *store) updateRating(movieID int64) {
+	movie, ok := s.movies[movieID]
+	if !ok {
+		return
+	}
+
+	var total, count int32
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			total += review.Rating
+			count++
+		}
+	}
+
+	movie.RatingCount = count
+	movie.AverageRating = 0
+	if count > 0 {
+		movie.AverageRating = float64(total) / float64(count)
+	}
+}
+
+func (m ReviewModel) Insert(ctx context.Context, review *data.Review) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if movie, ok := m.movies[review.MovieID]; !ok || movie.DeletedAt != nil {
+		return data.ErrRecordNotFound
+	}
+
+	for _, existing := range m.reviews {
+		if existing.MovieID == review.MovieID && existing.UserID == review.UserID {
+			return data.ErrDuplicateReview
+		}
+	}
+
+	m.lastReviewID++
+	review.ID = m.lastReviewID
+	review.CreatedAt = now()
+	review.Version = 1
+
+	copied := *review
+	m.reviews[review.ID] = &copied
+	m.updateRating(review.MovieID)
+	return nil
+}
+
+func (m ReviewModel) Get(ctx context.Context, id int64) (*data.Review, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	review, ok := m.reviews[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	copied := *review
+	return &copied, nil
+}
+
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int64, filters data.Filters) ([]*data.Review, data.Metadata, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reviews := []*data.Review{}
+	for _, review := range m.reviews {
+		if review.MovieID == movieID {
+			copied := *review
+			reviews = append(reviews, &copied)
+		}
+	}
+
+	// Order by the sort column, using the ID as a tie-breaker.
+	column, descending := filters.SortColumn(), filters.SortDirection() == "DESC"
+	sort.Slice(reviews, func(i, j int) bool {
+		a, b := reviews[i], reviews[j]
+
+		var cmp int
+		if column == "rating" {
+			cmp = compareValues(int64(a.Rating), int64(b.Rating))
+		} else {
+			cmp = compareValues(a.ID, b.ID)
+		}
+		if descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+		return a.ID < b.ID
+	})
+
+	totalRecords := len(reviews)
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	start := filters.Offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.Limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return reviews[start:end], metadata, nil
+}
+
+func (m ReviewModel) Update(ctx context.Context, review *data.Review) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[review.MovieID]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	existing, ok := m.reviews[review.ID]
+	if !ok || existing.Version != review.Version {
+		return data.ErrEditConflict
+	}
+
+	review.Version++
+	copied := *review
+	m.reviews[review.ID] = &copied
+	m.updateRating(review.MovieID)
+	return nil
+}
+
+func (m ReviewModel) Delete(ctx context.Context, review *data.Review) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[review.MovieID]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	if _, ok := m.reviews[review.ID]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.reviews, review.ID)
+	m.updateRating(review.MovieID)
+	return nil
+}
@@ -0,0 +1,69 @@
+package memstore
+
+import (
+	"context"
+	"github.com/ejacobg/greenlight/internal/data"
+)
+
+type MovieRevisionModel struct {
+	*store
+}
+
+// recordRevision saves a snapshot of the movie's current version. The caller must hold the store's lock.
+func (s *store) recordRevision(movie *data.Movie, userID int64) {
+	revision := &data.MovieRevision{
+		MovieID:   movie.ID,
+		Version:   movie.Version,
+		UserID:    userID,
+		CreatedAt: now(),
+		Title:     movie.Title,
+		Year:      movie.Year,
+		Runtime:   movie.Runtime,
+		Genres:    append([]string(nil), movie.Genres...),
+		Deleted:   movie.DeletedAt != nil,
+	}
+
+	s.revisions[movie.ID] = append(s.revisions[movie.ID], revision)
+}
+
+// copyRevision returns a deep copy of the given revision, so that callers can't modify the stored value.
+func copyRevision(revision *data.MovieRevision) *data.MovieRevision {
+	copied := *revision
+	copied.Genres = append([]string(nil), revision.Genres...)
+	return &copied
+}
+
+func (m MovieRevisionModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*data.MovieRevision, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Revisions are stored oldest first, but returned newest first.
+	stored := m.revisions[movieID]
+	revisions := make([]*data.MovieRevision, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		revisions = append(revisions, copyRevision(stored[i]))
+	}
+
+	return revisions, nil
+}
+
+func (m MovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*data.MovieRevision, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, revision := range m.revisions[movieID] {
+		if revision.Version == version {
+			return copyRevision(revision), nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
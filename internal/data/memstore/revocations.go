@@ -0,0 +1,42 @@
+package memstore
+
+import (
+	"context"
+	"time"
+)
+
+type RevocationModel struct {
+	*store
+}
+
+func (m RevocationModel) Insert(ctx context.Context, jti string, expiry time.Time) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, e := range m.revocations {
+		if e.Before(time.Now()) {
+			delete(m.revocations, id)
+		}
+	}
+
+	if _, ok := m.revocations[jti]; !ok {
+		m.revocations[jti] = expiry.Truncate(time.Second)
+	}
+	return nil
+}
+
+func (m RevocationModel) Exists(ctx context.Context, jti string) (bool, error) {
+	if err := contextError(ctx); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.revocations[jti]
+	return ok, nil
+}
@@ -0,0 +1,168 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"golang.org/x/exp/slices"
+)
+
+// seedRoles mirrors the roles inserted by the migrations.
+var seedRoles = []data.Role{
+	{Name: "viewer", Description: "Can browse movies, and manage their own reviews and lists.", Permissions: data.Permissions{"movies:read"}},
+	{Name: "editor", Description: "Can also add and edit movies.", Permissions: data.Permissions{"movies:read", "movies:write"}},
+	{Name: "admin", Description: "Can also restore deleted movies, manage genres, and manage user roles.", Permissions: data.Permissions{"movies:admin", "movies:read", "movies:write", "users:admin"}},
+}
+
+type RoleModel struct {
+	*store
+}
+
+// copyRole returns a copy of the role that shares no memory with the stored one.
+func copyRole(role *data.Role) *data.Role {
+	copied := *role
+	copied.Permissions = append(data.Permissions{}, role.Permissions...)
+	return &copied
+}
+
+func (m RoleModel) GetAll(ctx context.Context) ([]*data.Role, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roles := []*data.Role{}
+	for _, role := range m.roles {
+		roles = append(roles, copyRole(role))
+	}
+	return roles, nil
+}
+
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*data.Role, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	roles := []*data.Role{}
+	for _, role := range m.roles {
+		if m.usersRoles[userID][role.ID] {
+			roles = append(roles, copyRole(role))
+		}
+	}
+	return roles, nil
+}
+
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, name string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role := m.roleByName(name)
+	if role == nil {
+		return data.ErrRecordNotFound
+	}
+
+	// Mimic the foreign key on users_roles.user_id.
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf(`memstore: insert or update on table "users_roles" violates foreign key constraint "users_roles_user_id_fkey"`)
+	}
+
+	if m.usersRoles[userID] == nil {
+		m.usersRoles[userID] = make(map[int64]bool)
+	}
+	m.usersRoles[userID][role.ID] = true
+	return nil
+}
+
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, name string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role := m.roleByName(name)
+	if role == nil || !m.usersRoles[userID][role.ID] {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.usersRoles[userID], role.ID)
+	return nil
+}
+
+func (m RoleModel) Get(ctx context.Context, name string) (*data.Role, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role := m.roleByName(name)
+	if role == nil {
+		return nil, data.ErrRecordNotFound
+	}
+	return copyRole(role), nil
+}
+
+func (m RoleModel) AddPermission(ctx context.Context, name, code string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role := m.roleByName(name)
+	if role == nil || !slices.Contains(m.permissions, code) {
+		return data.ErrRecordNotFound
+	}
+
+	// Codes are kept sorted, like the array_agg in data.RoleModel.
+	if !role.Permissions.Include(code) {
+		role.Permissions = append(role.Permissions, code)
+		slices.Sort(role.Permissions)
+	}
+	return nil
+}
+
+func (m RoleModel) RemovePermission(ctx context.Context, name, code string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role := m.roleByName(name)
+	if role == nil {
+		return data.ErrRecordNotFound
+	}
+
+	i := slices.Index(role.Permissions, code)
+	if i < 0 {
+		return data.ErrRecordNotFound
+	}
+
+	role.Permissions = slices.Delete(role.Permissions, i, i+1)
+	return nil
+}
+
+// roleByName returns the stored role with the given name, or nil if there isn't one. The caller must hold the lock.
+func (s *store) roleByName(name string) *data.Role {
+	for _, role := range s.roles {
+		if role.Name == name {
+			return role
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package memstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/data"
+	"sort"
+	"time"
+)
+
+type TokenModel struct {
+	*store
+}
+
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	token, err := data.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m TokenModel) Insert(ctx context.Context, token *data.Token) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Mimic the foreign key on tokens.user_id.
+	if _, ok := m.users[token.UserID]; !ok {
+		return fmt.Errorf(`memstore: insert or update on table "tokens" violates foreign key constraint "tokens_user_id_fkey"`)
+	}
+
+	if _, ok := m.tokens[string(token.Hash)]; ok {
+		return fmt.Errorf(`memstore: duplicate key value violates unique constraint "tokens_pkey"`)
+	}
+
+	m.lastTokenID++
+	token.ID = m.lastTokenID
+	token.CreatedAt = now()
+
+	copied := *token
+	copied.Plaintext = "" // Only the hash is ever stored.
+	copied.Expiry = copied.Expiry.Truncate(time.Second)
+	copied.LastUsedAt = nil
+	copied.UsedAt = nil
+	m.tokens[string(token.Hash)] = &copied
+	return nil
+}
+
+func (m TokenModel) Touch(ctx context.Context, tokenPlaintext string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	token, ok := m.tokens[string(hash[:])]
+	if !ok {
+		return nil
+	}
+
+	usedAt := now()
+	if token.LastUsedAt == nil || token.LastUsedAt.Before(usedAt.Add(-data.SessionTouchInterval)) {
+		token.LastUsedAt = &usedAt
+	}
+	return nil
+}
+
+func (m TokenModel) GetSessionsForUser(ctx context.Context, userID int64) ([]*data.Session, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*data.Session{}
+	for _, token := range m.tokens {
+		if token.UserID != userID || token.Scope != data.ScopeAuthentication || !token.Expiry.After(time.Now()) {
+			continue
+		}
+
+		session := &data.Session{
+			ID:        token.ID,
+			Hash:      append([]byte{}, token.Hash...),
+			CreatedAt: token.CreatedAt,
+			Expiry:    token.Expiry,
+			UserAgent: token.UserAgent,
+			IP:        token.IP,
+		}
+		if token.LastUsedAt != nil {
+			lastUsedAt := *token.LastUsedAt
+			session.LastUsedAt = &lastUsedAt
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Mimic ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC.
+	lastActive := func(s *data.Session) time.Time {
+		if s.LastUsedAt != nil {
+			return *s.LastUsedAt
+		}
+		return s.CreatedAt
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := lastActive(sessions[i]), lastActive(sessions[j])
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (m TokenModel) DeleteSession(ctx context.Context, userID, id int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.tokens {
+		if token.ID == id && token.UserID == userID && token.Scope == data.ScopeAuthentication {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return data.ErrRecordNotFound
+}
+
+func (m TokenModel) UseRefresh(ctx context.Context, tokenPlaintext string) (*data.Token, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	token, ok := m.tokens[string(hash[:])]
+	if !ok || token.Scope != data.ScopeRefresh {
+		return nil, data.ErrRecordNotFound
+	}
+
+	if token.UsedAt != nil {
+		for hash, t := range m.tokens {
+			if t.Scope == data.ScopeRefresh && t.Family == token.Family {
+				delete(m.tokens, hash)
+			}
+		}
+		return nil, data.ErrTokenReused
+	}
+
+	if !token.Expiry.After(time.Now()) {
+		return nil, data.ErrRecordNotFound
+	}
+
+	usedAt := now()
+	token.UsedAt = &usedAt
+
+	copied := *token
+	copied.UsedAt = nil
+	copied.LastUsedAt = nil
+	return &copied, nil
+}
+
+func (m TokenModel) DeleteFamily(ctx context.Context, family string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.tokens {
+		if token.Scope == data.ScopeRefresh && token.Family == family {
+			delete(m.tokens, hash)
+		}
+	}
+	return nil
+}
+
+func (m TokenModel) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	token, ok := m.tokens[string(hash[:])]
+	if !ok || token.Scope != scope {
+		return data.ErrRecordNotFound
+	}
+
+	delete(m.tokens, string(hash[:]))
+	return nil
+}
+
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.tokens, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package memstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/ejacobg/greenlight/internal/data"
+	"strings"
+	"time"
+)
+
+type UserModel struct {
+	*store
+}
+
+// emailTaken reports whether another user already has the given email. Emails are compared case-insensitively, like the citext column in the database.
+func (m UserModel) emailTaken(email string, exceptID int64) bool {
+	for id, user := range m.users {
+		if id != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m UserModel) Insert(ctx context.Context, user *data.User) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return data.ErrDuplicateEmail
+	}
+
+	m.lastUserID++
+	user.ID = m.lastUserID
+	user.CreatedAt = now()
+	user.Version = 1
+
+	copied := *user
+	m.users[user.ID] = &copied
+	return nil
+}
+
+func (m UserModel) Get(ctx context.Context, id int64) (*data.User, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+
+	copied := *user
+	return &copied, nil
+}
+
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+
+	return nil, data.ErrRecordNotFound
+}
+
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, error) {
+	user, _, err := m.GetForTokenWithExpiry(ctx, tokenScope, tokenPlaintext)
+	return user, err
+}
+
+func (m UserModel) GetForTokenWithExpiry(ctx context.Context, tokenScope, tokenPlaintext string) (*data.User, time.Time, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	token, ok := m.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, time.Time{}, data.ErrRecordNotFound
+	}
+
+	user, ok := m.users[token.UserID]
+	if !ok {
+		return nil, time.Time{}, data.ErrRecordNotFound
+	}
+
+	copied := *user
+	return &copied, token.Expiry, nil
+}
+
+func (m UserModel) Update(ctx context.Context, user *data.User) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.users[user.ID]
+	if !ok || existing.Version != user.Version {
+		return data.ErrEditConflict
+	}
+
+	if m.emailTaken(user.Email, user.ID) {
+		return data.ErrDuplicateEmail
+	}
+
+	user.Version++
+
+	copied := *user
+	m.users[user.ID] = &copied
+	return nil
+}
+
+func (m UserModel) RevokeTokens(ctx context.Context, id int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+
+	user.TokensValidAfter = time.Now()
+	return nil
+}
+
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return data.ErrRecordNotFound
+	}
+
+	// Mimic the ON DELETE CASCADE (and SET NULL) of the tables that reference users.
+	delete(m.users, id)
+	delete(m.usersPermissions, id)
+	delete(m.usersRoles, id)
+
+	for keyID, key := range m.apiKeys {
+		if key.UserID == id {
+			delete(m.apiKeys, keyID)
+		}
+	}
+	for hash, token := range m.tokens {
+		if token.UserID == id {
+			delete(m.tokens, hash)
+		}
+	}
+	for reviewID, review := range m.reviews {
+		if review.UserID == id {
+			delete(m.reviews, reviewID)
+			m.updateRating(review.MovieID)
+		}
+	}
+	for _, list := range m.movieLists {
+		for key := range list {
+			if key.userID == id {
+				delete(list, key)
+			}
+		}
+	}
+	for _, revisions := range m.revisions {
+		for _, revision := range revisions {
+			if revision.UserID == id {
+				revision.UserID = 0
+			}
+		}
+	}
+	for key := range m.idempotency {
+		if key.userID == id {
+			delete(m.idempotency, key)
+		}
+	}
+	return nil
+}
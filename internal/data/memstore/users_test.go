@@ -0,0 +1,49 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ejacobg/greenlight/internal/data"
+)
+
+func TestUserErrors(t *testing.T) {
+	ctx := context.Background()
+	models := NewModels()
+
+	alice := &data.User{Name: "Alice", Email: "alice@example.com"}
+	bob := &data.User{Name: "Bob", Email: "bob@example.com"}
+	for _, user := range []*data.User{alice, bob} {
+		if err := models.Users.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Email addresses are compared case-insensitively, like the citext column.
+	duplicate := &data.User{Name: "Alice", Email: "ALICE@example.com"}
+	if err := models.Users.Insert(ctx, duplicate); !errors.Is(err, data.ErrDuplicateEmail) {
+		t.Errorf("Insert of a taken email: got %v; want ErrDuplicateEmail", err)
+	}
+
+	if _, err := models.Users.Get(ctx, 3); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("Get of a missing user: got %v; want ErrRecordNotFound", err)
+	}
+	if _, err := models.Users.GetByEmail(ctx, "carol@example.com"); !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("GetByEmail of a missing user: got %v; want ErrRecordNotFound", err)
+	}
+
+	bob.Email = alice.Email
+	if err := models.Users.Update(ctx, bob); !errors.Is(err, data.ErrDuplicateEmail) {
+		t.Errorf("Update to a taken email: got %v; want ErrDuplicateEmail", err)
+	}
+
+	stale := *alice
+	alice.Name = "Alice Smith"
+	if err := models.Users.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Users.Update(ctx, &stale); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("Update of a stale user: got %v; want ErrEditConflict", err)
+	}
+}
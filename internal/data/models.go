@@ -1,27 +1,193 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	// ErrQueryTimeout is returned when a query is abandoned because its deadline passed or its request was cancelled.
+	ErrQueryTimeout = errors.New("query timeout")
 )
 
+// queryError converts an error caused by an expired or cancelled context into ErrQueryTimeout. All other errors are returned unchanged.
+func queryError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ErrQueryTimeout, ctx.Err())
+	}
+	return err
+}
+
+// Models groups together every store used by the application.
+// Each field is an interface so that an alternative implementation (such as the in-memory one in the memstore package) can be swapped in for the PostgreSQL models.
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Tokens      TokenModel
-	Users       UserModel
+	APIKeys     APIKeyStore
+	Credits     CreditStore
+	Genres      GenreStore
+	Idempotency IdempotencyStore
+	Logins      LoginFailureStore
+	Movies      MovieStore
+	Revisions   MovieRevisionStore
+	People      PersonStore
+	Permissions PermissionStore
+	Reviews     ReviewStore
+	Revocations RevocationStore
+	Roles       RoleStore
+	Tokens      TokenStore
+	Users       UserStore
+	Watchlist   MovieListStore // Movies that each user plans to watch.
+	Watched     MovieListStore // Movies that each user has watched.
+}
+
+type APIKeyStore interface {
+	Insert(ctx context.Context, key *APIKey) error
+	GetForPlaintext(ctx context.Context, plaintext string) (*APIKey, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+type CreditStore interface {
+	Insert(ctx context.Context, credit *Credit) error
+	Get(ctx context.Context, id int64) (*Credit, error)
+	GetAllForMovie(ctx context.Context, movieID int64) ([]*Credit, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// GenreStore methods that change the genres of movies take the ID of the user making the change, which is recorded in the movies' revision histories.
+type GenreStore interface {
+	GetLookup(ctx context.Context) (GenreLookup, error)
+	GetAll(ctx context.Context) ([]*Genre, error)
+	Get(ctx context.Context, id int64) (*Genre, error)
+	Insert(ctx context.Context, genre *Genre) error
+	Update(ctx context.Context, genre *Genre, userID int64) error
+	Merge(ctx context.Context, sourceID, targetID int64, userID int64) (int64, error)
+}
+
+type IdempotencyStore interface {
+	Insert(ctx context.Context, record *IdempotencyRecord) error
+	Get(ctx context.Context, userID int64, key string) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	Delete(ctx context.Context, userID int64, key string) error
+}
+
+// LoginFailureStore counts failed logins per email address and per IP address, so that repeated password guesses can be slowed down.
+type LoginFailureStore interface {
+	Attempt(ctx context.Context, key string, policy LoginPolicy) (*LoginFailure, bool, error)
+	Release(ctx context.Context, key string, policy LoginPolicy) error
+	Delete(ctx context.Context, key string) error
+}
+
+// MovieStore methods that create a new version of a movie take the ID of the user making the change, which is recorded in the movie's revision history.
+type MovieStore interface {
+	Insert(ctx context.Context, movie *Movie, userID int64) error
+	InsertMany(ctx context.Context, movies []*Movie, userID int64) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetAll(ctx context.Context, filters MovieFilters) ([]*Movie, Metadata, error)
+	Export(ctx context.Context, filters MovieFilters, fn func(*Movie) error) error
+	Update(ctx context.Context, movie *Movie, userID int64) error
+	Delete(ctx context.Context, id int64, version int32, userID int64) error
+	Restore(ctx context.Context, id int64, userID int64) (*Movie, error)
+	UpdatePoster(ctx context.Context, movie *Movie) error
+	Purge(ctx context.Context, before time.Time) ([]int64, error)
+}
+
+type MovieRevisionStore interface {
+	GetAllForMovie(ctx context.Context, movieID int64) ([]*MovieRevision, error)
+	Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+}
+
+type ReviewStore interface {
+	Insert(ctx context.Context, review *Review) error
+	Get(ctx context.Context, id int64) (*Review, error)
+	GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error)
+	Update(ctx context.Context, review *Review) error
+	Delete(ctx context.Context, review *Review) error
+}
+
+type MovieListStore interface {
+	Add(ctx context.Context, userID, movieID int64) (time.Time, error)
+	Remove(ctx context.Context, userID, movieID int64) error
+	GetAll(ctx context.Context, userID int64, filters Filters) ([]*MovieListEntry, Metadata, error)
+}
+
+type PersonStore interface {
+	Insert(ctx context.Context, person *Person) error
+	Get(ctx context.Context, id int64) (*Person, error)
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error)
+	Update(ctx context.Context, person *Person) error
+	Delete(ctx context.Context, id int64) error
+}
+
+type PermissionStore interface {
+	GetAll(ctx context.Context) (Permissions, error)
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	GetAllDirectForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, code string) error
+}
+
+// RevocationStore holds the IDs of JWTs that have been revoked before they expire.
+type RevocationStore interface {
+	Insert(ctx context.Context, jti string, expiry time.Time) error
+	Exists(ctx context.Context, jti string) (bool, error)
+}
+
+type RoleStore interface {
+	GetAll(ctx context.Context) ([]*Role, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Role, error)
+	AddForUser(ctx context.Context, userID int64, name string) error
+	RemoveForUser(ctx context.Context, userID int64, name string) error
+	Get(ctx context.Context, name string) (*Role, error)
+	AddPermission(ctx context.Context, name, code string) error
+	RemovePermission(ctx context.Context, name, code string) error
+}
+
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	Touch(ctx context.Context, tokenPlaintext string) error
+	GetSessionsForUser(ctx context.Context, userID int64) ([]*Session, error)
+	DeleteSession(ctx context.Context, userID, id int64) error
+	UseRefresh(ctx context.Context, tokenPlaintext string) (*Token, error)
+	DeleteFamily(ctx context.Context, family string) error
+	Delete(ctx context.Context, scope, tokenPlaintext string) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	GetForTokenWithExpiry(ctx context.Context, tokenScope, tokenPlaintext string) (*User, time.Time, error)
+	Update(ctx context.Context, user *User) error
+	RevokeTokens(ctx context.Context, id int64) error
+	Delete(ctx context.Context, id int64) error
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the PostgreSQL models. Each query is given at most timeout to complete, on top of any deadline already set on its context.
+func NewModels(db *sql.DB, timeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		APIKeys:     APIKeyModel{DB: db, Timeout: timeout},
+		Credits:     CreditModel{DB: db, Timeout: timeout},
+		Genres:      GenreModel{DB: db, Timeout: timeout},
+		Idempotency: IdempotencyModel{DB: db, Timeout: timeout},
+		Logins:      LoginFailureModel{DB: db, Timeout: timeout},
+		Movies:      MovieModel{DB: db, Timeout: timeout},
+		Revisions:   MovieRevisionModel{DB: db, Timeout: timeout},
+		People:      PersonModel{DB: db, Timeout: timeout},
+		Permissions: PermissionModel{DB: db, Timeout: timeout},
+		Reviews:     ReviewModel{DB: db, Timeout: timeout},
+		Revocations: RevocationModel{DB: db, Timeout: timeout},
+		Roles:       RoleModel{DB: db, Timeout: timeout},
+		Tokens:      TokenModel{DB: db, Timeout: timeout},
+		Users:       UserModel{DB: db, Timeout: timeout},
+		Watchlist:   MovieListModel{DB: db, Timeout: timeout, Table: "watchlist"},
+		Watched:     MovieListModel{DB: db, Timeout: timeout, Table: "watched"},
 	}
 }
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MovieListEntry is a single movie on one of a user's lists, such as their watchlist.
+type MovieListEntry struct {
+	Movie   *Movie    `json:"movie"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// MovieListModel manages a table of movies saved by each user. The watchlist and watched tables have the same columns, so they share this model.
+type MovieListModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+	Table   string
+}
+
+// Add puts the movie on the user's list. If the movie is already on the list, then its added_at time is updated instead.
+// If the movie doesn't exist (or has been deleted), then ErrRecordNotFound is returned.
+func (m MovieListModel) Add(ctx context.Context, userID, movieID int64) (time.Time, error) {
+	query := fmt.Sprintf(`
+INSERT INTO %s (user_id, movie_id)
+SELECT $1, id
+FROM movies
+WHERE id = $2 AND deleted_at IS NULL
+ON CONFLICT (user_id, movie_id) DO UPDATE
+SET added_at = NOW()
+RETURNING added_at`, m.Table)
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var addedAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&addedAt)
+	if err != nil {
+		switch {
+		// Nothing is inserted if the movie couldn't be selected.
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, queryError(ctx, err)
+		}
+	}
+
+	return addedAt, nil
+}
+
+// Remove takes the movie off the user's list. If it wasn't on the list, then ErrRecordNotFound is returned.
+func (m MovieListModel) Remove(ctx context.Context, userID, movieID int64) error {
+	query := fmt.Sprintf(`
+DELETE FROM %s
+WHERE user_id = $1 AND movie_id = $2`, m.Table)
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll returns a page of the movies on the user's list. Deleted movies are left out until they are restored.
+// The sort column may be added_at, or any movie sort column other than relevance. Cursors are not supported.
+// None of the movies columns share a name with the list's columns, so they don't need to be qualified.
+func (m MovieListModel) GetAll(ctx context.Context, userID int64, filters Filters) ([]*MovieListEntry, Metadata, error) {
+	var args queryArgs
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), list.added_at, %s
+FROM %s list
+INNER JOIN movies ON movies.id = list.movie_id
+WHERE list.user_id = %s AND movies.deleted_at IS NULL
+ORDER BY %s %s, movies.id ASC
+LIMIT %s OFFSET %s`, movieColumns, m.Table, args.add(userID), movieColumn(filters.SortColumn()), filters.SortDirection(), args.add(filters.Limit()), args.add(filters.Offset()))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	entries := []*MovieListEntry{}
+	totalRecords := 0
+
+	for rows.Next() {
+		entry := MovieListEntry{Movie: &Movie{}}
+
+		err := rows.Scan(append([]any{&totalRecords, &entry.AddedAt}, entry.Movie.scanDest()...)...)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	return entries, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
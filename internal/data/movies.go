@@ -3,24 +3,58 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/ejacobg/greenlight/internal/validator"
 	"github.com/lib/pq"
+	"math"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"` // Timestamp of when movie was first added to the database.
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`    // Year of release.
-	Runtime   Runtime   `json:"runtime,omitempty"` // Runtime in minutes.
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"` // Starts at 1, increments with every update.
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"-"` // Timestamp of when movie was first added to the database.
+	Title         string     `json:"title"`
+	Year          int32      `json:"year,omitempty"`    // Year of release.
+	Runtime       Runtime    `json:"runtime,omitempty"` // Runtime in minutes.
+	Genres        []string   `json:"genres,omitempty"`
+	Version       int32      `json:"version"`        // Starts at 1, increments with every update.
+	AverageRating float64    `json:"average_rating"` // Mean rating of the movie's reviews, or zero if it hasn't been reviewed. Not covered by the version.
+	RatingCount   int32      `json:"rating_count"`
+	Highlight     string     `json:"highlight,omitempty"`  // Title with the matched search terms wrapped in <mark> tags. Only set when searching by title.
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"` // Set when the movie is deleted. Deleted movies are purged once their retention period is over.
+	Credits       []*Credit  `json:"credits,omitempty"`    // Only set when the credits are requested. Not covered by the version.
+	// The poster is exposed through its URL (see MarshalJSON). Not covered by the version.
+	PosterType      string     `json:"-"` // Content type of the original poster image, or empty if the movie doesn't have a poster.
+	PosterUpdatedAt *time.Time `json:"-"`
+	PosterVersion   int32      `json:"-"` // Incremented every time the poster is changed or removed.
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// PosterURL returns the path that the movie's poster is served from, or the empty string if it doesn't have one.
+// The path includes the poster's version, so that each version of the poster has a distinct URL that can be cached indefinitely.
+func (movie *Movie) PosterURL() string {
+	if movie.PosterType == "" || movie.PosterUpdatedAt == nil {
+		return ""
+	}
+	return fmt.Sprintf("/v1/movies/%d/poster?v=%d", movie.ID, movie.PosterVersion)
+}
+
+// MarshalJSON adds the poster_url field to the movie.
+func (movie Movie) MarshalJSON() ([]byte, error) {
+	// The alias has the same fields but none of the methods, which prevents MarshalJSON from calling itself.
+	type movieFields Movie
+
+	return json.Marshal(struct {
+		movieFields
+		PosterURL string `json:"poster_url,omitempty"`
+	}{movieFields(movie), movie.PosterURL()})
+}
+
+// ValidateMovie checks the movie, replacing each of its genres with the canonical slug from the lookup. Unknown genres are rejected.
+func ValidateMovie(v *validator.Validator, movie *Movie, genres GenreLookup) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 	v.Check(movie.Year != 0, "year", "must be provided")
@@ -29,32 +63,211 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
 	v.Check(movie.Genres != nil, "genres", "must be provided")
+
+	if movie.Genres != nil {
+		var unknown []string
+		movie.Genres, unknown = genres.Normalize(movie.Genres)
+		v.Check(len(unknown) == 0, "genres", "must not contain unknown genres: "+strings.Join(unknown, ", "))
+	}
+
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// MovieFilters holds the criteria used to search for movies, alongside the usual paging and sorting Filters.
+// Zero values (and empty slices) mean that the corresponding criterion is not applied.
+type MovieFilters struct {
+	Title          string
+	Fuzzy          bool     // Also match titles that are similar to (rather than containing) the search terms, to tolerate typos.
+	Genres         []string // Movies must have all of these genres.
+	AnyGenres      []string // Movies must have at least one of these genres.
+	ExcludeGenres  []string // Movies must have none of these genres.
+	YearMin        int
+	YearMax        int
+	RuntimeMin     int
+	RuntimeMax     int
+	PersonID       int64 // Movies must credit this person.
+	IncludeDeleted bool  // Also return movies that have been deleted, but not yet purged.
+	Filters
+}
+
+// ValidateMovieFilters checks the search criteria, as well as the embedded Filters. Errors are keyed by their query parameter.
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	ValidateMovieSearch(v, f)
+
+	// Relevance is calculated against the title search, and can't be used as a cursor position.
+	if f.Sort == "relevance" {
+		v.Check(f.Title != "", "sort", "relevance requires a title to search for")
+		v.Check(f.Cursor == "", "cursor", "must not be used when sorting by relevance")
+	}
+
+	ValidateFilters(v, f.Filters)
+}
+
+// ValidateMovieSearch checks only the search criteria, for use when the embedded Filters aren't needed.
+func ValidateMovieSearch(v *validator.Validator, f MovieFilters) {
+	for _, genres := range [][]string{f.Genres, f.AnyGenres, f.ExcludeGenres} {
+		for _, genre := range genres {
+			v.Check(genre != "", "genres", "must not contain empty values")
+		}
+	}
+
+	v.Check(f.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(f.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(f.YearMin == 0 || f.YearMax == 0 || f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(f.RuntimeMin == 0 || f.RuntimeMax == 0 || f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	v.Check(f.PersonID >= 0, "person_id", "must not be negative")
+}
+
+// prefixQuery converts a search string into a tsquery that matches every word as a prefix. For example, "fig club" becomes "fig:* & club:*".
+// Punctuation is dropped, so the result is always safe to pass to to_tsquery().
+func prefixQuery(search string) string {
+	words := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i := range words {
+		words[i] += ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// where returns the conditions used to filter the movies table, adding their arguments to args.
+// Each condition is skipped when its argument holds the zero value, in the same way as the title and genre conditions.
+func (f MovieFilters) where(args *queryArgs) string {
+	// Note that the % trigram similarity operator from pg_trgm has to be escaped for Sprintf.
+	return fmt.Sprintf(`(to_tsvector('simple', title) @@ to_tsquery('simple', %[1]s) OR %[1]s = '' OR (%[9]s AND title %% %[10]s))
+AND (genres @> %[2]s OR %[2]s = '{}')
+AND (genres && %[3]s OR %[3]s = '{}')
+AND NOT (genres && %[4]s)
+AND (year >= %[5]s OR %[5]s = 0)
+AND (year <= %[6]s OR %[6]s = 0)
+AND (runtime >= %[7]s OR %[7]s = 0)
+AND (runtime <= %[8]s OR %[8]s = 0)
+AND (deleted_at IS NULL OR %[11]s)
+AND (id IN (SELECT movie_id FROM credits WHERE person_id = %[12]s) OR %[12]s = 0)`,
+		args.add(prefixQuery(f.Title)),
+		args.add(textArray(f.Genres)),
+		args.add(textArray(f.AnyGenres)),
+		args.add(textArray(f.ExcludeGenres)),
+		args.add(f.YearMin),
+		args.add(f.YearMax),
+		args.add(f.RuntimeMin),
+		args.add(f.RuntimeMax),
+		args.add(f.Fuzzy),
+		args.add(f.Title),
+		args.add(f.IncludeDeleted),
+		args.add(f.PersonID),
+	)
+}
+
+// rank returns an expression scoring how closely each movie's title matches the title search.
+func (f MovieFilters) rank(args *queryArgs) string {
+	if f.Fuzzy {
+		return fmt.Sprintf("similarity(title, %s)", args.add(f.Title))
+	}
+	return fmt.Sprintf("ts_rank(to_tsvector('simple', title), to_tsquery('simple', %s))", args.add(prefixQuery(f.Title)))
+}
+
+// highlight returns an expression for the title with each matched search term wrapped in <mark> tags.
+// If there is no title search, then the expression is the empty string.
+func (f MovieFilters) highlight(args *queryArgs) string {
+	query := prefixQuery(f.Title)
+	if query == "" {
+		return "''"
+	}
+	return fmt.Sprintf("ts_headline('simple', title, to_tsquery('simple', %s), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')", args.add(query))
+}
+
+// orderBy returns the expression and direction that the filters' sort value refers to.
+// Relevance is not a column, so it is calculated with rank, and always puts the best matches first.
+func (f MovieFilters) orderBy(args *queryArgs) (string, string) {
+	if f.SortColumn() == "relevance" {
+		return f.rank(args), "DESC"
+	}
+	return movieColumn(f.SortColumn()), f.SortDirection()
+}
+
+// movieColumn returns the column that a sort value refers to. Most sort values share their column's name.
+func movieColumn(sort string) string {
+	if sort == "rating" {
+		return "average_rating"
+	}
+	return sort
+}
+
+// textArray converts a slice for use as a text[] argument. Unlike pq.Array, a nil slice becomes an empty array rather than NULL.
+func textArray(values []string) any {
+	if values == nil {
+		values = []string{}
+	}
+	return pq.Array(values)
+}
+
 type MovieModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
-	query := `
+// insertMovieQuery is shared by Insert and InsertMany.
+var insertMovieQuery = withRevision(`
 INSERT INTO movies (title, year, runtime, genres)
-VALUES ($1, $2, $3, $4)
-RETURNING id, created_at, version`
+VALUES ($1, $2, $3, $4)`, "$5", "id, created_at, version")
 
+func (m MovieModel) Insert(ctx context.Context, movie *Movie, userID int64) error {
 	// This slice technically isn't needed, but helps make clear what each placeholder value represents.
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), userID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	// Write the returned values back into the Movie object.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, insertMovieQuery, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return queryError(ctx, err)
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// InsertMany inserts all of the given movies within a single transaction. If any of the inserts fail, then none of the movies are saved.
+// Each insert is given its own m.Timeout, while the transaction as a whole is bound to ctx.
+func (m MovieModel) InsertMany(ctx context.Context, movies []*Movie, userID int64) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertMovieQuery)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		err = m.insertWith(ctx, stmt, movie, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return queryError(ctx, tx.Commit())
+}
+
+// insertWith runs a single insert for InsertMany using the prepared statement.
+func (m MovieModel) insertWith(ctx context.Context, stmt *sql.Stmt, movie *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), userID}
+
+	err := stmt.QueryRowContext(ctx, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return queryError(ctx, err)
+}
+
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	// The ID field in our database is of type bigserial, which starts at 1 and increments from there.
 	// We will check for a proper ID ourselves instead of wasting a database call.
 	if id < 1 {
@@ -62,27 +275,19 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	query := `
-SELECT id, created_at, title, year, runtime, genres, version
+SELECT ` + movieColumns + `
 FROM movies
-WHERE id = $1`
+WHERE id = $1 AND deleted_at IS NULL`
 
 	var movie Movie
 
-	// This query should take at most 3 seconds.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// This query should take at most m.Timeout, and is abandoned early if the parent context is cancelled.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 
 	// Cancelling with defer is common after setting a timeout.
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(movie.scanDest()...)
 
 	if err != nil {
 		switch {
@@ -90,30 +295,97 @@ WHERE id = $1`
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &movie, nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// movieColumns lists the columns read by Movie.scanDest, in order.
+const movieColumns = `id, created_at, title, year, runtime, genres, version, deleted_at, average_rating, rating_count, poster_type, poster_updated_at, poster_version`
+
+// scanDest returns the destinations needed to scan movieColumns into the movie.
+func (movie *Movie) scanDest() []any {
+	return []any{
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.DeletedAt,
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.PosterType,
+		&movie.PosterUpdatedAt,
+		&movie.PosterVersion,
+	}
+}
+
+// sortValue returns the value of the given sort column for this movie, for use in a Cursor.
+func (movie *Movie) sortValue(column string) any {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return int64(movie.Year)
+	case "runtime":
+		// Convert to a plain integer so that Runtime's custom JSON format isn't used.
+		return int64(movie.Runtime)
+	case "rating":
+		return movie.AverageRating
+	default:
+		return movie.ID
+	}
+}
+
+// ValidMovieCursor reports whether the cursor's value has the same type as the given sort column, so that it can be compared against it.
+// DecodeCursor returns any JSON number, so integer columns must also be checked for fractions and their range.
+func ValidMovieCursor(cursor Cursor, column string) bool {
+	switch value := cursor.Value.(type) {
+	case string:
+		return column == "title"
+	case int64:
+		switch column {
+		case "year", "runtime":
+			return value >= math.MinInt32 && value <= math.MaxInt32
+		default:
+			return column != "title"
+		}
+	case float64:
+		return column == "rating"
+	default:
+		return false
+	}
+}
+
+// GetAll returns a page of movies matching the given filters.
+// If filters.Cursor is set, then the page starts from the cursor's position. Otherwise, filters.Page is used.
+func (m MovieModel) GetAll(ctx context.Context, filters MovieFilters) ([]*Movie, Metadata, error) {
+	if filters.Cursor != "" {
+		return m.getAllByCursor(ctx, filters)
+	}
+
+	var args queryArgs
+	highlight := filters.highlight(&args)
+	where := filters.where(&args)
+	orderBy, direction := filters.orderBy(&args)
+
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+SELECT count(*) OVER(), %s, %s
 FROM movies
-WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-AND (genres @> $2 OR $2 = '{}')
+WHERE %s
 ORDER BY %s %s, id ASC
-LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+LIMIT %s OFFSET %s`, movieColumns, highlight, where, orderBy, direction, args.add(filters.Limit()), args.add(filters.Offset()))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -125,39 +397,196 @@ LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 	for rows.Next() {
 		var movie Movie
 
-		err := rows.Scan(
-			&totalRecords, // Obtain the value returned by the window function (is the same for all rows).
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
-
+		// Obtain the value returned by the window function (is the same for all rows).
+		dest := append([]any{&totalRecords}, movie.scanDest()...)
+		err := rows.Scan(append(dest, &movie.Highlight)...)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, queryError(ctx, err)
 		}
 
 		movies = append(movies, &movie)
 	}
 
 	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	// Give clients a cursor for the next page, so that they can switch over to keyset pagination.
+	if metadata.CurrentPage < metadata.LastPage && len(movies) > 0 && filters.Sort != "relevance" {
+		last := movies[len(movies)-1]
+		metadata.NextCursor = Cursor{Sort: filters.Sort, Value: last.sortValue(filters.SortColumn()), ID: last.ID}.Encode()
+	}
+
+	return movies, metadata, nil
+}
+
+// getAllByCursor returns the page of movies immediately after (or before) the filter's cursor.
+// Rather than skipping over rows with OFFSET, the query seeks straight to the cursor's (sort column, id) position.
+func (m MovieModel) getAllByCursor(ctx context.Context, filters MovieFilters) ([]*Movie, Metadata, error) {
+	cursor, err := filters.cursor()
+	if err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	column := filters.SortColumn()
+	if column == "relevance" {
+		return nil, Metadata{}, ErrInvalidCursor
+	}
+
+	// Reject cursors whose value doesn't have the same type as the column, rather than letting the query fail.
+	if !ValidMovieCursor(cursor, column) {
+		return nil, Metadata{}, ErrInvalidCursor
+	}
+
+	// Rows are ordered the same way as in page mode: by the sort column, then by ascending ID.
+	// Paging backwards flips both orderings (and comparisons), after which the results are reversed.
+	columnDirection, columnComparison := filters.SortDirection(), ">"
+	if columnDirection == "DESC" {
+		columnComparison = "<"
+	}
+	idDirection, idComparison := "ASC", ">"
+	if cursor.Backward {
+		columnDirection, columnComparison = reverseDirection(columnDirection)
+		idDirection, idComparison = reverseDirection(idDirection)
+	}
+
+	var args queryArgs
+	highlight := filters.highlight(&args)
+	where := filters.where(&args)
+	expr := movieColumn(column)
+	value, id := args.add(cursor.Value), args.add(cursor.ID)
+
+	// Fetch one extra row to find out if there is another page after this one.
+	query := fmt.Sprintf(`
+SELECT %s, %s
+FROM movies
+WHERE %s
+AND (%s %s %s OR (%s = %s AND id %s %s))
+ORDER BY %s %s, id %s
+LIMIT %s`, movieColumns, highlight, where, expr, columnComparison, value, expr, value, idComparison, id, expr, columnDirection, idDirection, args.add(filters.Limit()+1))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(append(movie.scanDest(), &movie.Highlight)...)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	more := len(movies) > filters.Limit()
+	if more {
+		movies = movies[:filters.Limit()]
+	}
+
+	if cursor.Backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	if len(movies) > 0 {
+		first, last := movies[0], movies[len(movies)-1]
+
+		// We arrived here from a cursor, so there is always a page in the direction we came from.
+		if more || cursor.Backward {
+			metadata.NextCursor = Cursor{Sort: filters.Sort, Value: last.sortValue(column), ID: last.ID}.Encode()
+		}
+		if more || !cursor.Backward {
+			metadata.PrevCursor = Cursor{Sort: filters.Sort, Value: first.sortValue(column), ID: first.ID, Backward: true}.Encode()
+		}
+	}
+
+	if filters.IncludeTotal {
+		metadata.TotalRecords, err = m.count(ctx, filters)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
 
 	return movies, metadata, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
-	query := `
+// Export calls fn with each movie matching the given filters, in order of ID. The paging and sorting fields of the filters are ignored.
+// Rows are streamed from the database one at a time, so memory use doesn't grow with the number of movies. If fn returns an error, then the export stops and that error is returned.
+// Since an export may take much longer than m.Timeout, the query is only bound to ctx.
+func (m MovieModel) Export(ctx context.Context, filters MovieFilters, fn func(*Movie) error) error {
+	var args queryArgs
+	query := fmt.Sprintf(`
+SELECT %s
+FROM movies
+WHERE %s
+ORDER BY id ASC`, movieColumns, filters.where(&args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(movie.scanDest()...)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return queryError(ctx, rows.Err())
+}
+
+// reverseDirection returns the opposite of the given sort direction, along with the keyset comparison operator for the new direction.
+func reverseDirection(direction string) (string, string) {
+	if direction == "ASC" {
+		return "DESC", "<"
+	}
+	return "ASC", ">"
+}
+
+// count returns the number of movies matching the given filters.
+func (m MovieModel) count(ctx context.Context, filters MovieFilters) (int, error) {
+	var args queryArgs
+	query := fmt.Sprintf(`
+SELECT count(*)
+FROM movies
+WHERE %s`, filters.where(&args))
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&total)
+	return total, queryError(ctx, err)
+}
+
+func (m MovieModel) Update(ctx context.Context, movie *Movie, userID int64) error {
+	query := withRevision(`
 UPDATE movies
 SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-WHERE id = $5 AND version = $6
-RETURNING version`
+WHERE id = $5 AND version = $6 AND deleted_at IS NULL`, "$7", "version")
 
 	args := []interface{}{
 		movie.Title,
@@ -166,9 +595,10 @@ RETURNING version`
 		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version,
+		userID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
@@ -178,39 +608,131 @@ RETURNING version`
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete marks the movie with the given ID as deleted. It will be hidden from every other method (except Restore), until it is purged.
+// If version is non-zero, then the movie is only deleted if it still has that version. Like Update, a version mismatch is reported as ErrEditConflict.
+func (m MovieModel) Delete(ctx context.Context, id int64, version int32, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `
-DELETE FROM movies
-WHERE id = $1`
+	query := withRevision(`
+UPDATE movies
+SET deleted_at = NOW(), version = version + 1
+WHERE id = $1 AND (version = $2 OR $2 = 0) AND deleted_at IS NULL`, "$3", "id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	err := m.DB.QueryRowContext(ctx, query, id, version, userID).Scan(&id)
 	if err != nil {
-		return err
+		switch {
+		// If no rows were returned, then the item with the given ID (and version) was not found.
+		case errors.Is(err, sql.ErrNoRows) && version != 0:
+			return ErrEditConflict
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Restore undoes the deletion of a movie that has not yet been purged, and returns the restored movie.
+// If the movie doesn't exist (or was never deleted), then ErrRecordNotFound is returned.
+func (m MovieModel) Restore(ctx context.Context, id int64, userID int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	query := withRevision(`
+UPDATE movies
+SET deleted_at = NULL, version = version + 1
+WHERE id = $1 AND deleted_at IS NOT NULL`, "$2", movieColumns)
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var movie Movie
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(movie.scanDest()...)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
 	}
 
-	// If no rows were affected, then the item with the given ID was not found.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	return &movie, nil
+}
+
+// UpdatePoster records that the movie's poster has changed to one with the given content type, sets PosterUpdatedAt to the time of the change, and increments PosterVersion.
+// An empty PosterType records that the poster was removed. Like Update, if the movie has been deleted or its PosterVersion has changed, then ErrEditConflict is returned.
+func (m MovieModel) UpdatePoster(ctx context.Context, movie *Movie) error {
+	query := `
+UPDATE movies
+SET poster_type = $1, poster_updated_at = CASE WHEN $1 = '' THEN NULL ELSE NOW() END, poster_version = poster_version + 1
+WHERE id = $2 AND poster_version = $3 AND deleted_at IS NULL
+RETURNING poster_updated_at, poster_version`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movie.PosterType, movie.ID, movie.PosterVersion).Scan(&movie.PosterUpdatedAt, &movie.PosterVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
 	}
 
 	return nil
 }
+
+// Purge permanently removes the movies that were deleted before the given time, and returns the IDs of the movies that were removed.
+func (m MovieModel) Purge(ctx context.Context, before time.Time) ([]int64, error) {
+	query := `
+DELETE FROM movies
+WHERE deleted_at < $1
+RETURNING id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestValidMovieCursor(t *testing.T) {
+	tests := []struct {
+		column string
+		value  string
+		want   bool
+	}{
+		{"title", `"Moana"`, true},
+		{"title", `2016`, false},
+		{"year", `2016`, true},
+		{"year", `1.5`, false},
+		{"year", `"2016"`, false},
+		{"year", `9999999999`, false},
+		{"runtime", `107`, true},
+		{"runtime", `107.5`, false},
+		{"id", `1`, true},
+		{"id", `1.5`, false},
+		{"rating", `4`, true},
+		{"rating", `4.5`, true},
+		{"rating", `"4.5"`, false},
+	}
+
+	for _, tt := range tests {
+		// Cursors are built by hand, the same way that a client could craft one.
+		js := `{"s":"` + tt.column + `","v":` + tt.value + `,"i":1}`
+		cursor, err := DecodeCursor(base64.RawURLEncoding.EncodeToString([]byte(js)))
+		if err != nil {
+			t.Fatalf("%s: %v", js, err)
+		}
+
+		if got := ValidMovieCursor(cursor, tt.column); got != tt.want {
+			t.Errorf("%s cursor with value %s: got %t; want %t", tt.column, tt.value, got, tt.want)
+		}
+	}
+}
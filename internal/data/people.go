@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"time"
+)
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"` // Zero if unknown.
+	Version   int32     `json:"version"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+}
+
+type PersonModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// personColumns lists the columns read by Person.scanDest, in order.
+const personColumns = `id, created_at, name, COALESCE(birth_year, 0), version`
+
+// scanDest returns the destinations needed to scan personColumns into the person.
+func (person *Person) scanDest() []any {
+	return []any{
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Version,
+	}
+}
+
+// birthYear converts an unknown (zero) birth year into NULL.
+func (person *Person) birthYear() any {
+	if person.BirthYear == 0 {
+		return nil
+	}
+	return person.BirthYear
+}
+
+func (m PersonModel) Insert(ctx context.Context, person *Person) error {
+	query := `
+INSERT INTO people (name, birth_year)
+VALUES ($1, $2)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, person.Name, person.birthYear()).Scan(&person.ID, &person.CreatedAt, &person.Version)
+	return queryError(ctx, err)
+}
+
+func (m PersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT ` + personColumns + `
+FROM people
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var person Person
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(person.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &person, nil
+}
+
+// GetAll returns a page of people whose names match the search, in the same way as movie titles. An empty name matches everyone.
+// Cursors are not supported.
+func (m PersonModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	var args queryArgs
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM people
+WHERE (to_tsvector('simple', name) @@ to_tsquery('simple', %[2]s) OR %[2]s = '')
+ORDER BY %s %s, id ASC
+LIMIT %s OFFSET %s`, personColumns, args.add(prefixQuery(name)), filters.SortColumn(), filters.SortDirection(), args.add(filters.Limit()), args.add(filters.Offset()))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	people := []*Person{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(append([]any{&totalRecords}, person.scanDest()...)...)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	return people, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update saves changes to the person, using the version for optimistic locking in the same way as MovieModel.Update.
+func (m PersonModel) Update(ctx context.Context, person *Person) error {
+	query := `
+UPDATE people
+SET name = $1, birth_year = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []interface{}{person.Name, person.birthYear(), person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the person, along with all of their credits.
+func (m PersonModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+DELETE FROM people
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -22,24 +22,83 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
 }
 
-// GetAllForUser returns all permissions granted to a specific user. It uses the same pattern as MovieModel.GetAll.
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAll returns every known permission code.
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+SELECT code
+FROM permissions
+ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return permissions, nil
+}
+
+// GetAllForUser returns all permissions granted to a specific user, either directly or through one of their roles.
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+SELECT permissions.code
+FROM permissions
+WHERE permissions.id IN (
+    SELECT users_permissions.permission_id
+    FROM users_permissions
+    WHERE users_permissions.user_id = $1
+    UNION
+    SELECT roles_permissions.permission_id
+    FROM roles_permissions
+    INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+    WHERE users_roles.user_id = $1
+)
+ORDER BY permissions.code`
+
+	return m.queryForUser(ctx, query, userID)
+}
+
+// GetAllDirectForUser returns the permissions granted to a specific user directly, leaving out those granted through their roles.
+func (m PermissionModel) GetAllDirectForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 SELECT permissions.code
 FROM permissions
 INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-INNER JOIN users ON users_permissions.user_id = users.id
-WHERE users.id = $1`
+WHERE users_permissions.user_id = $1
+ORDER BY permissions.code`
+
+	return m.queryForUser(ctx, query, userID)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// queryForUser runs a query for a user's permission codes, taking the user's ID as its only argument.
+func (m PermissionModel) queryForUser(ctx context.Context, query string, userID int64) (Permissions, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -48,27 +107,56 @@ WHERE users.id = $1`
 		var permission string
 		err := rows.Scan(&permission)
 		if err != nil {
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 		permissions = append(permissions, permission)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 
 	return permissions, nil
 }
 
-// AddForUser will grant the given permissions to the given user.
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// AddForUser will grant the given permissions to the given user. Permissions that the user already has, and unknown codes, are skipped.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 INSERT INTO users_permissions
-SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	return queryError(ctx, err)
+}
+
+// RemoveForUser takes a permission that was granted directly away from the user. ErrRecordNotFound is returned if the user wasn't granted it directly.
+// The user keeps the permission if one of their roles grants it.
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, code string) error {
+	query := `
+DELETE FROM users_permissions
+USING permissions
+WHERE users_permissions.permission_id = permissions.id AND users_permissions.user_id = $1 AND permissions.code = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, code)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
 }
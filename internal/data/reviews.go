@@ -0,0 +1,246 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/ejacobg/greenlight/internal/validator"
+	"time"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"` // The author of the review. Only they may change it.
+	Rating    int32     `json:"rating"`  // From 1 to 5.
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// reviewColumns lists the columns read by Review.scanDest, in order.
+const reviewColumns = `id, created_at, movie_id, user_id, rating, body, version`
+
+// scanDest returns the destinations needed to scan reviewColumns into the review.
+func (review *Review) scanDest() []any {
+	return []any{
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	}
+}
+
+// updateRating recalculates the average_rating and rating_count of the given movie from its reviews.
+func updateRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `
+UPDATE movies
+SET (average_rating, rating_count) = (SELECT COALESCE(avg(rating), 0), count(*) FROM reviews WHERE movie_id = $1)
+WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
+
+// withMovieLock runs fn in a transaction that holds a lock on the given movie, then updates the movie's rating before committing.
+// Every change to a movie's reviews goes through here, so that concurrent changes can't leave the rating out of date.
+// If the movie doesn't exist (or if it has been deleted and allowDeleted is false), then ErrRecordNotFound is returned.
+// The transaction as a whole is given m.Timeout to complete.
+func (m ReviewModel) withMovieLock(ctx context.Context, movieID int64, allowDeleted bool, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	query := `
+SELECT deleted_at IS NOT NULL
+FROM movies
+WHERE id = $1
+FOR UPDATE`
+
+	var deleted bool
+
+	err = tx.QueryRowContext(ctx, query, movieID).Scan(&deleted)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || (deleted && !allowDeleted):
+		return ErrRecordNotFound
+	case err != nil:
+		return queryError(ctx, err)
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = updateRating(ctx, tx, movieID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	return queryError(ctx, tx.Commit())
+}
+
+// Insert adds a review to a movie. If the movie doesn't exist, then ErrRecordNotFound is returned.
+// If the user has already reviewed the movie, then ErrDuplicateReview is returned.
+func (m ReviewModel) Insert(ctx context.Context, review *Review) error {
+	query := `
+INSERT INTO reviews (movie_id, user_id, rating, body)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`
+
+	args := []interface{}{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	return m.withMovieLock(ctx, review.MovieID, false, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+				return ErrDuplicateReview
+			default:
+				return queryError(ctx, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (m ReviewModel) Get(ctx context.Context, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT ` + reviewColumns + `
+FROM reviews
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var review Review
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(review.scanDest()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie returns a page of the given movie's reviews. Cursors are not supported.
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	var args queryArgs
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM reviews
+WHERE movie_id = %s
+ORDER BY %s %s, id ASC
+LIMIT %s OFFSET %s`, reviewColumns, args.add(movieID), filters.SortColumn(), filters.SortDirection(), args.add(filters.Limit()), args.add(filters.Offset()))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	reviews := []*Review{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(append([]any{&totalRecords}, review.scanDest()...)...)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	return reviews, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update saves changes to the review's rating and body, using the version for optimistic locking in the same way as MovieModel.Update.
+func (m ReviewModel) Update(ctx context.Context, review *Review) error {
+	query := `
+UPDATE reviews
+SET rating = $1, body = $2, version = version + 1
+WHERE id = $3 AND version = $4
+RETURNING version`
+
+	args := []interface{}{review.Rating, review.Body, review.ID, review.Version}
+
+	// Reviews of deleted movies may still be changed, since they will be needed if the movie is restored.
+	return m.withMovieLock(ctx, review.MovieID, true, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return queryError(ctx, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the review. If it has already been removed, then ErrRecordNotFound is returned.
+func (m ReviewModel) Delete(ctx context.Context, review *Review) error {
+	query := `
+DELETE FROM reviews
+WHERE id = $1`
+
+	return m.withMovieLock(ctx, review.MovieID, true, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, review.ID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"golang.org/x/exp/slices"
+	"time"
+)
+
+// MovieRevision is a snapshot of a movie, taken whenever a new version of the movie is saved.
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	UserID    int64     `json:"user_id,omitempty"` // Zero if the user is unknown, such as for revisions recorded before history was kept.
+	CreatedAt time.Time `json:"created_at"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	Deleted   bool      `json:"deleted"`
+}
+
+// FieldChange describes a single field that differs between two revisions.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// DiffRevisions lists the fields that changed between the two revisions, in the same order as the MovieRevision fields.
+func DiffRevisions(from, to *MovieRevision) []FieldChange {
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	changes := []FieldChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{"title", from.Title, to.Title})
+	}
+	if from.Year != to.Year {
+		changes = append(changes, FieldChange{"year", from.Year, to.Year})
+	}
+	if from.Runtime != to.Runtime {
+		changes = append(changes, FieldChange{"runtime", from.Runtime, to.Runtime})
+	}
+	if !slices.Equal(from.Genres, to.Genres) {
+		changes = append(changes, FieldChange{"genres", from.Genres, to.Genres})
+	}
+	if from.Deleted != to.Deleted {
+		changes = append(changes, FieldChange{"deleted", from.Deleted, to.Deleted})
+	}
+
+	return changes
+}
+
+// withRevision wraps a query that changes a single movie, so that a snapshot of the new version is saved to movie_revisions within the same statement.
+// The query must not have its own RETURNING clause. userID is the placeholder holding the acting user's ID (or zero if unknown),
+// and the wrapped query returns the given columns of the changed movie.
+func withRevision(query, userID, returning string) string {
+	return fmt.Sprintf(`
+WITH movie AS (%s
+RETURNING *
+), revision AS (
+INSERT INTO movie_revisions (movie_id, version, user_id, title, year, runtime, genres, deleted)
+SELECT id, version, NULLIF(%s::bigint, 0), title, year, runtime, genres, deleted_at IS NOT NULL
+FROM movie
+)
+SELECT %s
+FROM movie`, query, userID, returning)
+}
+
+type MovieRevisionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// revisionColumns lists the columns read by MovieRevision.scanDest, in order.
+const revisionColumns = `movie_id, version, user_id, created_at, title, year, runtime, genres, deleted`
+
+// scanDest returns the destinations needed to scan revisionColumns into the revision.
+// The user ID is scanned separately, since it may be NULL.
+func (revision *MovieRevision) scanDest(userID *sql.NullInt64) []any {
+	return []any{
+		&revision.MovieID,
+		&revision.Version,
+		userID,
+		&revision.CreatedAt,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.Deleted,
+	}
+}
+
+// GetAllForMovie returns every revision of the given movie, newest first.
+func (m MovieRevisionModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*MovieRevision, error) {
+	query := `
+SELECT ` + revisionColumns + `
+FROM movie_revisions
+WHERE movie_id = $1
+ORDER BY version DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	// Instantiate an empty (rather than nil) slice so that the returned JSON will always be an array.
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var (
+			revision MovieRevision
+			userID   sql.NullInt64
+		)
+
+		err := rows.Scan(revision.scanDest(&userID)...)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		revision.UserID = userID.Int64
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return revisions, nil
+}
+
+// Get returns a single version of the given movie.
+func (m MovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+SELECT ` + revisionColumns + `
+FROM movie_revisions
+WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var (
+		revision MovieRevision
+		userID   sql.NullInt64
+	)
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(revision.scanDest(&userID)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+	revision.UserID = userID.Int64
+
+	return &revision, nil
+}
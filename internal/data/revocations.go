@@ -0,0 +1,50 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevocationModel records JWTs that were revoked (for example, on logout) before they expired.
+// JWTs are identified by their "jti" claim.
+type RevocationModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// Insert revokes the JWT with the given ID. The expiry should match the JWT's own, after which the record is no longer needed.
+// Records for JWTs that have since expired are removed at the same time.
+func (m RevocationModel) Insert(ctx context.Context, jti string, expiry time.Time) error {
+	query := `
+WITH expired AS (
+    DELETE FROM revoked_jwts
+    WHERE expiry < NOW()
+)
+INSERT INTO revoked_jwts (jti, expiry)
+VALUES ($1, $2)
+ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, jti, expiry)
+	return queryError(ctx, err)
+}
+
+// Exists reports whether the JWT with the given ID has been revoked.
+func (m RevocationModel) Exists(ctx context.Context, jti string) (bool, error) {
+	query := `
+SELECT EXISTS(SELECT 1 FROM revoked_jwts WHERE jti = $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, jti).Scan(&exists)
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Role is a named bundle of permissions that can be granted to users.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
+}
+
+// roleQuery selects every role along with its permission codes. A WHERE clause can be appended to filter the roles.
+const roleQuery = `
+SELECT roles.id, roles.name, roles.description, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+FROM roles
+LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id`
+
+// GetAll returns every role.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	query := roleQuery + `
+GROUP BY roles.id
+ORDER BY roles.id`
+
+	return m.query(ctx, query)
+}
+
+// GetAllForUser returns the roles granted to a specific user.
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]*Role, error) {
+	query := roleQuery + `
+WHERE roles.id IN (SELECT role_id FROM users_roles WHERE user_id = $1)
+GROUP BY roles.id
+ORDER BY roles.id`
+
+	return m.query(ctx, query, userID)
+}
+
+// Get returns the role with the given name. ErrRecordNotFound is returned if there isn't one.
+func (m RoleModel) Get(ctx context.Context, name string) (*Role, error) {
+	query := roleQuery + `
+WHERE roles.name = $1
+GROUP BY roles.id`
+
+	roles, err := m.query(ctx, query, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roles) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return roles[0], nil
+}
+
+func (m RoleModel) query(ctx context.Context, query string, args ...any) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return roles, nil
+}
+
+// AddForUser grants the named role to the user. Granting a role that the user already has does nothing.
+// ErrRecordNotFound is returned if there is no role with the given name.
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, name string) error {
+	query := `
+WITH role AS (
+    SELECT id
+    FROM roles
+    WHERE name = $2
+), granted AS (
+    INSERT INTO users_roles (user_id, role_id)
+    SELECT $1, id FROM role
+    ON CONFLICT DO NOTHING
+)
+SELECT EXISTS(SELECT 1 FROM role)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, userID, name).Scan(&exists)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RemoveForUser takes the named role away from the user. ErrRecordNotFound is returned if the user doesn't have the role.
+// Permissions granted directly to the user are not affected.
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, name string) error {
+	query := `
+DELETE FROM users_roles
+USING roles
+WHERE users_roles.role_id = roles.id AND users_roles.user_id = $1 AND roles.name = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, name)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddPermission adds the permission to the named role, granting it to every user with the role. Adding a permission that the role already has does nothing.
+// ErrRecordNotFound is returned if there is no role with the given name, or no permission with the given code.
+func (m RoleModel) AddPermission(ctx context.Context, name, code string) error {
+	query := `
+WITH role AS (
+    SELECT id
+    FROM roles
+    WHERE name = $1
+), permission AS (
+    SELECT id
+    FROM permissions
+    WHERE code = $2
+), added AS (
+    INSERT INTO roles_permissions (role_id, permission_id)
+    SELECT role.id, permission.id FROM role, permission
+    ON CONFLICT DO NOTHING
+)
+SELECT EXISTS(SELECT 1 FROM role) AND EXISTS(SELECT 1 FROM permission)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, name, code).Scan(&exists)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RemovePermission takes the permission away from the named role, and from every user with the role (unless they are granted it some other way).
+// ErrRecordNotFound is returned if the role doesn't have the permission.
+func (m RoleModel) RemovePermission(ctx context.Context, name, code string) error {
+	query := `
+DELETE FROM roles_permissions
+USING roles, permissions
+WHERE roles_permissions.role_id = roles.id AND roles_permissions.permission_id = permissions.id AND roles.name = $1 AND permissions.code = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, name, code)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -45,3 +45,21 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	*r = Runtime(i)
 	return nil
 }
+
+// MarshalCSV formats the runtime as a plain number of minutes, which is easier to work with in a spreadsheet than the JSON form.
+func (r Runtime) MarshalCSV() (string, error) {
+	return strconv.FormatInt(int64(r), 10), nil
+}
+
+// UnmarshalCSV parses a runtime from a CSV field. Both the JSON form ("<runtime> mins") and a plain number of minutes are accepted.
+func (r *Runtime) UnmarshalCSV(value string) error {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "mins"))
+
+	i, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return ErrInvalidRuntimeFormat
+	}
+
+	*r = Runtime(i)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"github.com/ejacobg/greenlight/internal/validator"
 	"time"
 )
@@ -13,33 +14,61 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeEmailChange    = "email-change" // Verifies a user's new email address before it replaces the current one.
 	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh" // Exchanged for a new JWT when running in JWT mode.
 )
 
+// ErrTokenReused is returned when a refresh token is used a second time. This suggests that the token was stolen, so its whole family is revoked.
+var ErrTokenReused = errors.New("token reused")
+
+// SessionTouchInterval is how often a session's last used time is updated. Updating it on every request would turn every read into a write.
+const SessionTouchInterval = time.Minute
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"` // A token's scope defines how it is being used in the application.
+
+	// The remaining fields describe the session that an authentication token belongs to.
+	ID         int64      `json:"-"`
+	CreatedAt  time.Time  `json:"-"`
+	LastUsedAt *time.Time `json:"-"`
+	UserAgent  string     `json:"-"`
+	IP         string     `json:"-"`
+
+	// Refresh tokens rotated from the same login share a family. A refresh token is marked as used, rather than deleted, so that reuse can be detected.
+	Family string     `json:"-"`
+	UsedAt *time.Time `json:"-"`
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+// Session describes an authentication token, without revealing the token itself.
+type Session struct {
+	ID         int64      `json:"id"`
+	Hash       []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"` // Nil if the token has never been used.
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"` // Set by the caller if this is the session making the request.
+}
+
+// GenerateToken creates a new random token for the given user. The token is not saved anywhere.
+func GenerateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := Token{
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
 		Scope:  scope,
 	}
 
-	// Create and fill a byte slice with random values.
-	randomBytes := make([]byte, 16)
-	_, err := rand.Read(randomBytes)
+	plaintext, err := randomString()
 	if err != nil {
 		return nil, err
 	}
-
-	// Encode the byte slice to a base-32-encoded string.
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Plaintext = plaintext
 
 	// Create a hash of the token string to be stored in the database.
 	hash := sha256.Sum256([]byte(token.Plaintext))
@@ -48,51 +77,246 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return &token, nil
 }
 
+// NewTokenFamily returns a random identifier for a new family of refresh tokens. It can also be used as a JWT ID.
+func NewTokenFamily() (string, error) {
+	return randomString()
+}
+
+// randomString returns a random 26-character string.
+func randomString() (string, error) {
+	// Create and fill a byte slice with random values.
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// Encode the byte slice to a base-32-encoded string.
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
 // Check that the plaintext token has been provided and is exactly 26 bytes long.
-// Encoding a 16-byte value under base-32 (like in generateToken) will produce a 26-character string.
+// Encoding a 16-byte value under base-32 (like in GenerateToken) will produce a 26-character string.
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
 }
 
 // New will create and save a token for the given user.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := GenerateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
+INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip, family)
+VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+RETURNING id, created_at`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.Family}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+	return queryError(ctx, err)
+}
+
+// Touch records that the token was just used. To limit writes, the token is only updated if it hasn't been touched within the last SessionTouchInterval.
+func (m TokenModel) Touch(ctx context.Context, tokenPlaintext string) error {
+	query := `
+UPDATE tokens
+SET last_used_at = NOW()
+WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - make_interval(secs => $2))`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], SessionTouchInterval.Seconds())
+	return queryError(ctx, err)
+}
+
+// GetSessionsForUser returns the user's unexpired authentication tokens, most recently used first.
+func (m TokenModel) GetSessionsForUser(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+SELECT id, hash, created_at, last_used_at, expiry, user_agent, ip
+FROM tokens
+WHERE user_id = $1 AND scope = $2 AND expiry > NOW()
+ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.Hash,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession deletes one of the user's authentication tokens by its ID.
+// ErrRecordNotFound is returned if the token doesn't exist or belongs to another user.
+func (m TokenModel) DeleteSession(ctx context.Context, userID, id int64) error {
+	query := `
+DELETE FROM tokens
+WHERE id = $1 AND user_id = $2 AND scope = $3`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UseRefresh marks a refresh token as used, and returns it so that a replacement can be issued in the same family.
+// If the token has already been used, every token in its family is deleted and ErrTokenReused is returned.
+func (m TokenModel) UseRefresh(ctx context.Context, tokenPlaintext string) (*Token, error) {
+	query := `
+UPDATE tokens
+SET used_at = NOW()
+WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > NOW()
+RETURNING user_id, expiry, family`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	token := Token{Hash: tokenHash[:], Scope: ScopeRefresh}
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err == nil {
+		return &token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, queryError(ctx, err)
+	}
+
+	// The token is either unknown, expired, or has already been used. Only the last case revokes the family.
+	query = `
+DELETE FROM tokens
+WHERE scope = $2 AND family IN (
+    SELECT family
+    FROM tokens
+    WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL
+)`
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], ScopeRefresh)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected > 0 {
+		return nil, ErrTokenReused
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// DeleteFamily deletes every refresh token in the given family.
+func (m TokenModel) DeleteFamily(ctx context.Context, family string) error {
+	query := `
+DELETE FROM tokens
+WHERE scope = $1 AND family = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ScopeRefresh, family)
+	return queryError(ctx, err)
+}
+
+// Delete deletes a single token, given its plaintext and scope.
+func (m TokenModel) Delete(ctx context.Context, scope, tokenPlaintext string) error {
+	query := `
+DELETE FROM tokens
+WHERE hash = $1 AND scope = $2`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 DELETE FROM tokens
 WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
-	return err
+	return queryError(ctx, err)
 }
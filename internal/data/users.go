@@ -22,7 +22,11 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	// PendingEmail is an email address that the user has asked to change to, but hasn't verified yet.
+	PendingEmail string `json:"pending_email,omitempty"`
+	Version      int    `json:"-"`
+	// TokensValidAfter is when the user's sessions were last revoked. JWTs issued before this time are rejected.
+	TokensValidAfter time.Time `json:"-"`
 }
 
 func (u *User) IsAnonymous() bool {
@@ -88,10 +92,32 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // Maximum duration of each query.
 }
 
-func (m UserModel) Insert(user *User) error {
+// userColumns lists the columns read by User.scanDest, in order. qualifiedUserColumns is the same list, for queries that join other tables.
+const (
+	userColumns          = `id, created_at, name, email, password_hash, activated, version, COALESCE(pending_email, ''), COALESCE(tokens_valid_after, 'epoch')`
+	qualifiedUserColumns = `users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, COALESCE(users.pending_email, ''), COALESCE(users.tokens_valid_after, 'epoch')`
+)
+
+// scanDest returns the destinations needed to scan userColumns into the user.
+func (user *User) scanDest() []any {
+	return []any{
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.PendingEmail,
+		&user.TokensValidAfter,
+	}
+}
+
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 INSERT INTO users (name, email, password_hash, activated)
 VALUES ($1, $2, $3, $4)
@@ -99,7 +125,7 @@ RETURNING id, created_at, version`
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -109,51 +135,43 @@ RETURNING id, created_at, version`
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT ` + userColumns + `
 FROM users
 WHERE email = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	// Because emails are unique, we expect to return at most 1 row.
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(user.scanDest()...)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = NULLIF($7, ''), version = version + 1
 WHERE id = $5 AND version = $6
 RETURNING version`
 
@@ -164,9 +182,10 @@ RETURNING version`
 		user.Activated,
 		user.ID,
 		user.Version,
+		user.PendingEmail,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -178,16 +197,22 @@ RETURNING version`
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	user, _, err := m.GetForTokenWithExpiry(ctx, tokenScope, tokenPlaintext)
+	return user, err
+}
+
+// GetForTokenWithExpiry is the same as GetForToken, but also returns when the token expires.
+func (m UserModel) GetForTokenWithExpiry(ctx context.Context, tokenScope, tokenPlaintext string) (*User, time.Time, error) {
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+SELECT ` + qualifiedUserColumns + `, tokens.expiry
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
@@ -202,60 +227,153 @@ AND tokens.expiry > $3`
 	// Remember that Sum256 returns an array. We use the slice operator to turn it into a slice.
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	var user User
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
+	var (
+		user   User
+		expiry time.Time
 	)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(append(user.scanDest(), &expiry)...)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, time.Time{}, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, time.Time{}, queryError(ctx, err)
 		}
 	}
 
-	return &user, nil
+	return &user, expiry, nil
 }
 
-func (m UserModel) Get(id int64) (*User, error) {
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT ` + userColumns + `
 FROM users
 WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var user User
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&user.ID,
-		&user.CreatedAt,
-		&user.Name,
-		&user.Email,
-		&user.Password.hash,
-		&user.Activated,
-		&user.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(user.scanDest()...)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &user, nil
 }
+
+// RevokeTokens rejects every JWT issued to the user before now. Unlike Update, it doesn't change the user's version, so it can't cause an edit conflict.
+func (m UserModel) RevokeTokens(ctx context.Context, id int64) error {
+	query := `
+UPDATE users
+SET tokens_valid_after = $2
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, time.Now())
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete removes the user. Their tokens, API keys, permissions, roles, reviews, and movie lists are removed along with them (by ON DELETE CASCADE), as are their idempotency keys.
+// The revisions they made are kept, but are no longer attributed to them.
+// The ratings of the movies they reviewed are recalculated in the same transaction, so that they never include the deleted reviews.
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	// Lock the reviewed movies first (in a consistent order), as ReviewModel does before changing their reviews.
+	_, err = tx.ExecContext(ctx, `
+SELECT id
+FROM movies
+WHERE id IN (SELECT movie_id FROM reviews WHERE user_id = $1)
+ORDER BY id
+FOR UPDATE`, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	// The reviews are deleted here rather than by the cascade, so that the movies of any reviews added since the lock are recalculated too.
+	rows, err := tx.QueryContext(ctx, `
+DELETE FROM reviews
+WHERE user_id = $1
+RETURNING movie_id`, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var movieIDs []int64
+	for rows.Next() {
+		var movieID int64
+		if err := rows.Scan(&movieID); err != nil {
+			return queryError(ctx, err)
+		}
+		movieIDs = append(movieIDs, movieID)
+	}
+	if err = rows.Err(); err != nil {
+		return queryError(ctx, err)
+	}
+
+	query := `
+WITH idempotency_keys AS (
+    DELETE FROM idempotency_keys
+    WHERE user_id = $1
+)
+DELETE FROM users
+WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	for _, movieID := range movieIDs {
+		err = updateRating(ctx, tx, movieID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+	}
+
+	return queryError(ctx, tx.Commit())
+}
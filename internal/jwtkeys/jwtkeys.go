@@ -0,0 +1,282 @@
+// Package jwtkeys loads the keys used to sign and verify JWTs, and publishes their public halves as a JSON Web Key Set.
+//
+// Keys are read from PEM files in a directory, and each key's ID is its file name without the ".pem" extension.
+// Every key ID starts with the time the key becomes active, in the form 20060102T150405Z, so sorting the IDs sorts the keys by age.
+// The newest active private key signs new tokens. Once a newer private key becomes active, the older keys keep verifying tokens for a grace window, and are then retired.
+// This lets keys be rotated without logging anyone out: a new key can be added ahead of its activation time, so that it is published before it signs anything.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/pascaldekloe/jwt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoSigningKey = errors.New("jwtkeys: no active private key found")
+	ErrUnknownKey   = errors.New("jwtkeys: unknown key ID")
+	ErrRetiredKey   = errors.New("jwtkeys: key has been retired")
+)
+
+// minRSABits is the smallest RSA key that will be loaded.
+const minRSABits = 2048
+
+// ActivationLayout is the layout of the activation time at the start of each key ID, such as 20240501T000000Z.
+const ActivationLayout = "20060102T150405Z"
+
+// Key is a single public key, along with the algorithm it is used with.
+type Key struct {
+	ID          string
+	Algorithm   string           // Either jwt.RS256 or jwt.EdDSA.
+	Public      crypto.PublicKey // Either *rsa.PublicKey or ed25519.PublicKey.
+	ActivatesAt time.Time        // When the key starts signing tokens, if it is a private key. Taken from the start of the ID.
+	RetiresAt   time.Time        // When the key stops verifying tokens. Zero if no newer private key has been added.
+}
+
+// Retired reports whether the key should no longer be used to verify tokens at time t.
+func (k *Key) Retired(t time.Time) bool {
+	return !k.RetiresAt.IsZero() && !t.Before(k.RetiresAt)
+}
+
+// Set holds every key loaded from a directory.
+type Set struct {
+	signers  []*Key                   // The private keys, oldest first.
+	privates map[string]crypto.Signer // Either *rsa.PrivateKey or ed25519.PrivateKey, by key ID.
+	keys     map[string]*Key
+}
+
+// Load reads every PEM file in dir. Files may hold either a private key or (for keys that only need to verify tokens) a public key.
+// Each key retires grace after the first private key that is newer than it becomes active. At least one private key must already be active.
+func Load(dir string, grace time.Duration) (*Set, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	set := &Set{privates: make(map[string]crypto.Signer), keys: make(map[string]*Key)}
+
+	for _, path := range paths {
+		key, private, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		set.keys[key.ID] = key
+		if private != nil {
+			set.privates[key.ID] = private
+			set.signers = append(set.signers, key)
+		}
+	}
+
+	// The activation time is at the start of the ID, so sorting by ID is enough to put the keys in order.
+	sort.Slice(set.signers, func(i, j int) bool {
+		return set.signers[i].ID < set.signers[j].ID
+	})
+
+	if set.signer(time.Now()) == nil {
+		return nil, fmt.Errorf("%w in %s", ErrNoSigningKey, dir)
+	}
+
+	for _, key := range set.keys {
+		for _, signer := range set.signers {
+			if signer.ID > key.ID {
+				key.RetiresAt = signer.ActivatesAt.Add(grace)
+				break
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// signer returns the newest private key that is active at time t, or nil if there isn't one.
+func (s *Set) signer(t time.Time) *Key {
+	for i := len(s.signers) - 1; i >= 0; i-- {
+		if !s.signers[i].ActivatesAt.After(t) {
+			return s.signers[i]
+		}
+	}
+	return nil
+}
+
+// parseActivation returns the activation time at the start of the key ID.
+func parseActivation(id string) (time.Time, error) {
+	if len(id) >= len(ActivationLayout) {
+		if t, err := time.Parse(ActivationLayout, id[:len(ActivationLayout)]); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("key ID must start with its activation time, such as %s", ActivationLayout)
+}
+
+// loadFile parses the first PEM block in the file at path. The private key is nil if the file only holds a public key.
+func loadFile(path string) (*Key, crypto.Signer, error) {
+	id := strings.TrimSuffix(filepath.Base(path), ".pem")
+	activatesAt, err := parseActivation(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jwtkeys: %s: %w", path, err)
+	}
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(text)
+	if block == nil {
+		return nil, nil, fmt.Errorf("jwtkeys: %s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("jwtkeys: %s: %w", path, err)
+	}
+
+	key := &Key{
+		ID:          id,
+		ActivatesAt: activatesAt,
+	}
+
+	var private crypto.Signer
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.Public, private = jwt.RS256, &k.PublicKey, k
+	case *rsa.PublicKey:
+		key.Algorithm, key.Public = jwt.RS256, k
+	case ed25519.PrivateKey:
+		key.Algorithm, key.Public, private = jwt.EdDSA, k.Public(), k
+	case ed25519.PublicKey:
+		key.Algorithm, key.Public = jwt.EdDSA, k
+	default:
+		return nil, nil, fmt.Errorf("jwtkeys: %s: unsupported key type %T", path, parsed)
+	}
+
+	if public, ok := key.Public.(*rsa.PublicKey); ok && public.N.BitLen() < minRSABits {
+		return nil, nil, fmt.Errorf("jwtkeys: %s: RSA keys must be at least %d bits", path, minRSABits)
+	}
+
+	return key, private, nil
+}
+
+// SigningKeyID returns the ID of the key used to sign new tokens at time t.
+func (s *Set) SigningKeyID(t time.Time) string {
+	return s.signer(t).ID
+}
+
+// Sign signs the claims with the signing key, and records the key's ID in the token's "kid" header.
+func (s *Set) Sign(claims *jwt.Claims) ([]byte, error) {
+	// Load checked that a key was active, and keys never stop being active, so there is always a signer.
+	signer := s.signer(time.Now())
+	claims.KeyID = signer.ID
+
+	switch private := s.privates[signer.ID].(type) {
+	case *rsa.PrivateKey:
+		return claims.RSASign(jwt.RS256, private)
+	case ed25519.PrivateKey:
+		return claims.EdDSASign(private)
+	default:
+		return nil, fmt.Errorf("jwtkeys: unsupported key type %T", private)
+	}
+}
+
+// Check verifies the token's signature using the key named by its "kid" header, and returns its claims.
+// Tokens signed by an unknown key, or by a key that has retired by time t, are rejected.
+// As with the jwt package, Claims.Valid should be used to complete the verification.
+func (s *Set) Check(token []byte, t time.Time) (*jwt.Claims, error) {
+	unverified, err := jwt.ParseWithoutCheck(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := s.keys[unverified.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if key.Retired(t) {
+		return nil, ErrRetiredKey
+	}
+
+	// Both of these reject tokens whose "alg" header doesn't match the type of key.
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		return jwt.RSACheck(token, public)
+	case ed25519.PublicKey:
+		return jwt.EdDSACheck(token, public)
+	default:
+		return nil, fmt.Errorf("jwtkeys: unsupported key type %T", public)
+	}
+}
+
+// JWK is the JSON Web Key representation of a public key, as described in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+
+	// RSA parameters.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519 parameters.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS returns the keys that can verify tokens at time t, newest first.
+// Retired keys are left out, so that other services stop accepting tokens signed with them.
+func (s *Set) JWKS(t time.Time) []JWK {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		if !key.Retired(t) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID > keys[j].ID
+	})
+
+	enc := base64.RawURLEncoding
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		jwk := JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = enc.EncodeToString(public.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = enc.EncodeToString(public)
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
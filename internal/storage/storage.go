@@ -0,0 +1,47 @@
+// Package storage provides a simple key-value store for files, such as movie posters.
+// Keys are slash-separated paths (for example, "posters/1/original"), so that backends which only support flat keys can still group related files.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: object not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Object is a stored file, opened for reading. It must be closed once it is no longer needed.
+type Object struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is implemented by each storage backend.
+type Storage interface {
+	// Put saves the contents of r under the given key, replacing any existing object.
+	// Readers will either see the old object or the new one, but never a partially written one.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the object stored under the given key. If there isn't one, then ErrNotFound is returned.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored under the given key. Deleting an object that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeleteAll removes every object whose key lies beneath the given prefix, i.e. starts with prefix + "/".
+	DeleteAll(ctx context.Context, prefix string) error
+}
+
+// validKey reports whether the key is a clean, relative, slash-separated path that can't refer to anything outside the store.
+func validKey(key string) bool {
+	return key != "" &&
+		key == path.Clean(key) &&
+		!path.IsAbs(key) &&
+		key != ".." &&
+		!strings.HasPrefix(key, "../") &&
+		!strings.Contains(key, `\`)
+}
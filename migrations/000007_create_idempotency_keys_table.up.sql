@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    user_id      bigint                      NOT NULL, -- Anonymous requests are stored under user 0.
+    key          text                        NOT NULL,
+    request_hash bytea                       NOT NULL,
+    status       integer,                              -- NULL while the original request is still in flight.
+    headers      jsonb,
+    body         bytea,
+    created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry       timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,2 @@
+-- Requires the pg_trgm extension (see sql/extensions.sql).
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Only deleted movies are indexed, since the purge is the only query that searches by deletion time.
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Allows listing deleted movies.
+INSERT INTO permissions (code)
+VALUES ('movies:admin');
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS movie_revisions
+(
+    movie_id   bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version    integer                     NOT NULL,
+    user_id    bigint                      REFERENCES users ON DELETE SET NULL, -- NULL if the user is unknown.
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title      text                        NOT NULL,
+    year       integer                     NOT NULL,
+    runtime    integer                     NOT NULL,
+    genres     text[]                      NOT NULL,
+    deleted    boolean                     NOT NULL DEFAULT false,
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Existing movies start their history at their current version, since earlier versions weren't recorded.
+INSERT INTO movie_revisions (movie_id, version, created_at, title, year, runtime, genres, deleted)
+SELECT id, version, created_at, title, year, runtime, genres, deleted_at IS NOT NULL
+FROM movies
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_average_rating_idx;
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS average_rating,
+    DROP COLUMN IF EXISTS rating_count;
+DROP TABLE IF EXISTS reviews;
\ No newline at end of file
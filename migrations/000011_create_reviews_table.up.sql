@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS reviews
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id   bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating     integer                     NOT NULL,
+    body       text                        NOT NULL DEFAULT '',
+    version    integer                     NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id) -- Users may only review each movie once.
+);
+
+ALTER TABLE reviews
+    ADD CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 5);
+
+-- The rating of each movie is kept up to date as its reviews change, so that movies can be sorted by rating.
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS average_rating double precision NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS rating_count   integer          NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating, id);
@@ -0,0 +1,17 @@
+-- Movies that each user plans to watch.
+CREATE TABLE IF NOT EXISTS watchlist
+(
+    user_id  bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+-- Movies that each user has already watched. The two tables share the same columns, so that they can use the same model.
+CREATE TABLE IF NOT EXISTS watched
+(
+    user_id  bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint                      NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
@@ -0,0 +1,75 @@
+CREATE TABLE IF NOT EXISTS genres
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    slug       text UNIQUE                 NOT NULL, -- The canonical value stored in movies.genres.
+    name       text                        NOT NULL  -- For display.
+);
+
+-- Aliases are other spellings that are normalized to a genre's slug. Like slugs, they are stored in slug form.
+CREATE TABLE IF NOT EXISTS genre_aliases
+(
+    alias    text PRIMARY KEY,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+-- Start with a set of common genres, so that movies can be created straight away.
+INSERT INTO genres (slug, name)
+VALUES ('action', 'Action'),
+       ('adventure', 'Adventure'),
+       ('animation', 'Animation'),
+       ('comedy', 'Comedy'),
+       ('crime', 'Crime'),
+       ('documentary', 'Documentary'),
+       ('drama', 'Drama'),
+       ('family', 'Family'),
+       ('fantasy', 'Fantasy'),
+       ('horror', 'Horror'),
+       ('musical', 'Musical'),
+       ('mystery', 'Mystery'),
+       ('romance', 'Romance'),
+       ('sci-fi', 'Science Fiction'),
+       ('thriller', 'Thriller'),
+       ('war', 'War'),
+       ('western', 'Western')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT 'science-fiction', id
+FROM genres
+WHERE slug = 'sci-fi'
+ON CONFLICT DO NOTHING;
+
+-- Backfill the genres that are already in use. A slug is the lowercase genre, with each run of other characters replaced by a hyphen.
+-- This must match data.Slugify.
+CREATE TEMPORARY TABLE movie_genre_slugs AS
+SELECT DISTINCT genre, trim(both '-' from regexp_replace(lower(genre), '[^[:alnum:]]+', '-', 'g')) AS slug
+FROM movies, unnest(genres) AS genre;
+
+-- Genres that only match an alias are left alone, since they already normalize to another genre.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (s.slug) s.slug, s.genre
+FROM movie_genre_slugs s
+WHERE s.slug <> ''
+  AND NOT EXISTS (SELECT 1 FROM genre_aliases a WHERE a.alias = s.slug)
+ORDER BY s.slug, s.genre
+ON CONFLICT DO NOTHING;
+
+-- Replace each movie's genres with their canonical slugs, dropping any duplicates while keeping the original order.
+UPDATE movies
+SET genres = ARRAY(
+        SELECT c.slug
+        FROM unnest(movies.genres) WITH ORDINALITY AS u(genre, position)
+                 INNER JOIN movie_genre_slugs s ON s.genre = u.genre
+                 INNER JOIN genres c ON c.id = COALESCE((SELECT a.genre_id FROM genre_aliases a WHERE a.alias = s.slug),
+                                                        (SELECT g.id FROM genres g WHERE g.slug = s.slug))
+        GROUP BY c.slug
+        ORDER BY min(u.position)
+    )
+-- Movies with a genre that has no slug (such as one made only of punctuation) are left for an administrator to fix, rather than losing the genre.
+WHERE NOT EXISTS (SELECT 1
+                  FROM unnest(movies.genres) AS g(genre)
+                           INNER JOIN movie_genre_slugs s ON s.genre = g.genre
+                  WHERE s.slug = '');
+
+DROP TABLE movie_genre_slugs;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS people
+(
+    id         bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name       text                        NOT NULL,
+    birth_year integer, -- NULL if unknown.
+    version    integer                     NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+-- Each credit links a person to a movie in one role. The same person may be credited for several roles in a movie, and actors may play several characters.
+CREATE TABLE IF NOT EXISTS credits
+(
+    id        bigserial PRIMARY KEY,
+    movie_id  bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role      text   NOT NULL,
+    character text   NOT NULL DEFAULT '', -- Only used for actors.
+    UNIQUE (movie_id, person_id, role, character)
+);
+
+ALTER TABLE credits
+    ADD CONSTRAINT credits_role_check CHECK (role IN ('director', 'writer', 'producer', 'actor', 'composer', 'cinematographer', 'editor'));
+
+-- Supports filtering movies by person.
+CREATE INDEX IF NOT EXISTS credits_person_id_idx ON credits (person_id);
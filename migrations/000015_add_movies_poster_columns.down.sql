@@ -0,0 +1,3 @@
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS poster_type,
+    DROP COLUMN IF EXISTS poster_updated_at;
@@ -0,0 +1,4 @@
+-- The poster itself is kept in file storage. These columns record whether there is one, and how to serve it.
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS poster_type       text NOT NULL DEFAULT '', -- Content type of the original image, or empty if there is no poster.
+    ADD COLUMN IF NOT EXISTS poster_updated_at timestamp(0) with time zone;
@@ -0,0 +1,3 @@
+-- A new email address is kept here until it has been verified, at which point it replaces the current one.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS pending_email citext;
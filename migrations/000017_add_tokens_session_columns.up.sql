@@ -0,0 +1,10 @@
+-- Authentication tokens double as sessions, so record enough about each one for users to recognise it.
+-- The hash remains the primary key, but the id can be shown to users without exposing it.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS id           bigserial UNIQUE,
+    ADD COLUMN IF NOT EXISTS created_at   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS user_agent   text                        NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip           text                        NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id);
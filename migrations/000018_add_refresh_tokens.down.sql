@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS revoked_jwts;
+
+DROP INDEX IF EXISTS tokens_family_idx;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS used_at,
+    DROP COLUMN IF EXISTS family;
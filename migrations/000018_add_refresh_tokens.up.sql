@@ -0,0 +1,13 @@
+-- Refresh tokens are rotated on every use. Each one records the family it was rotated from, and is kept after use so that reuse can be detected.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS family  text,
+    ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family) WHERE family IS NOT NULL;
+
+-- JWTs that have been revoked before their expiry. Rows can be removed once the JWT would have expired anyway.
+CREATE TABLE IF NOT EXISTS revoked_jwts
+(
+    jti    text PRIMARY KEY,
+    expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,40 @@
+-- Roles bundle permission codes together, so that users can be granted a role rather than individual permissions.
+-- Permissions granted directly through users_permissions still apply alongside those granted by roles.
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          bigserial PRIMARY KEY,
+    name        text UNIQUE NOT NULL,
+    description text        NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- Allows managing the roles of other users.
+INSERT INTO permissions (code)
+VALUES ('users:admin');
+
+INSERT INTO roles (name, description)
+VALUES ('viewer', 'Can browse movies, and manage their own reviews and lists.'),
+       ('editor', 'Can also add and edit movies.'),
+       ('admin', 'Can also restore deleted movies, manage genres, and manage user roles.');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles
+INNER JOIN permissions ON permissions.code = ANY (CASE roles.name
+    WHEN 'viewer' THEN ARRAY ['movies:read']
+    WHEN 'editor' THEN ARRAY ['movies:read', 'movies:write']
+    WHEN 'admin' THEN ARRAY ['movies:read', 'movies:write', 'movies:admin', 'users:admin']
+END);
@@ -0,0 +1,13 @@
+-- Long-lived keys for machine clients. Like tokens, only a hash of each key is stored.
+-- Each key is limited to the listed permission codes, on top of the permissions of the user who owns it.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id          bigserial PRIMARY KEY,
+    created_at  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id     bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash        bytea UNIQUE                NOT NULL,
+    name        text                        NOT NULL,
+    permissions text[]                      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
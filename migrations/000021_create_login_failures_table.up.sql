@@ -0,0 +1,9 @@
+-- Failed logins, counted per email address and per IP address so that every instance of the API sees the same counts.
+-- Keys are of the form "email:<address>" or "ip:<address>".
+CREATE TABLE IF NOT EXISTS login_failures
+(
+    key            text PRIMARY KEY,
+    failures       integer                  NOT NULL,
+    last_failed_at timestamp with time zone NOT NULL,
+    locked_until   timestamp with time zone NOT NULL
+);
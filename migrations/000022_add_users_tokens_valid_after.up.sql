@@ -0,0 +1,3 @@
+-- JWTs issued before this time are rejected, so that every session can be revoked at once. NULL means that none have been revoked.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tokens_valid_after timestamp with time zone;
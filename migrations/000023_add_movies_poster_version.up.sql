@@ -0,0 +1,3 @@
+-- Incremented every time the poster changes, so that each version of the poster has a distinct URL and ETag.
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS poster_version integer NOT NULL DEFAULT 0;
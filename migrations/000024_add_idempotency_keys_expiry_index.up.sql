@@ -0,0 +1,2 @@
+-- Expired keys are removed whenever a new key is inserted.
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);
@@ -0,0 +1,2 @@
+-- Forgotten failures are removed whenever a login is attempted.
+CREATE INDEX IF NOT EXISTS login_failures_last_failed_at_idx ON login_failures (last_failed_at);